@@ -0,0 +1,194 @@
+package certreloader
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	// DefaultPollInterval is how often the certificate and key files are
+	// checked for changes.
+	DefaultPollInterval = 10 * time.Second
+
+	ReloadSucceededCounter = "TLSCertificateReloadSucceeded"
+	ReloadFailedCounter    = "TLSCertificateReloadFailed"
+)
+
+// Reloader serves the certificate and key found in a pair of files and
+// swaps them for the new pair whenever either file changes. A pair that
+// cannot be loaded, does not match or has expired is rejected and the
+// previous one stays in use.
+type Reloader struct {
+	logger       lager.Logger
+	certFile     string
+	keyFile      string
+	pollInterval time.Duration
+	clock        clock.Clock
+	metronClient loggingclient.IngressClient
+	audit        audit.Recorder
+
+	cert     atomic.Pointer[tls.Certificate]
+	versions [2]fileVersion
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// Option configures optional behaviour of the Reloader.
+type Option func(*Reloader)
+
+// WithAuditRecorder records every reload attempt in recorder.
+func WithAuditRecorder(recorder audit.Recorder) Option {
+	return func(r *Reloader) {
+		r.audit = recorder
+	}
+}
+
+// New loads the initial pair and fails if it is not valid.
+func New(
+	logger lager.Logger,
+	certFile, keyFile string,
+	pollInterval time.Duration,
+	clock clock.Clock,
+	metronClient loggingclient.IngressClient,
+	opts ...Option,
+) (*Reloader, error) {
+	r := &Reloader{
+		logger:       logger.Session("cert-reloader", lager.Data{"cert-file": certFile, "key-file": keyFile}),
+		certFile:     certFile,
+		keyFile:      keyFile,
+		pollInterval: pollInterval,
+		clock:        clock,
+		metronClient: metronClient,
+		audit:        audit.Discard,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	versions, err := r.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	r.versions = versions
+
+	return r, nil
+}
+
+// GetCertificate is meant to be used as tls.Config.GetCertificate.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.cert.Load(), nil
+}
+
+// Check fails once the certificate being served has expired, e.g. because
+// it was not replaced in time.
+func (r *Reloader) Check() error {
+	notAfter := r.cert.Load().Leaf.NotAfter
+	if r.clock.Now().After(notAfter) {
+		return fmt.Errorf("certificate expired at %s", notAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Run polls the files until it is signalled.
+func (r *Reloader) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := r.clock.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			r.reloadIfChanged()
+		case <-signals:
+			return nil
+		}
+	}
+}
+
+func (r *Reloader) reloadIfChanged() {
+	versions, err := r.stat()
+	if err != nil {
+		r.logger.Error("failed-to-stat", err)
+		return
+	}
+	if versions == r.versions {
+		return
+	}
+	// a rejected pair is not retried until one of the files changes again
+	r.versions = versions
+
+	event := audit.Event{
+		Time:      r.clock.Now(),
+		Type:      audit.EventConfigReload,
+		Component: "cert-reloader",
+		Details:   map[string]string{"cert_file": r.certFile, "key_file": r.keyFile},
+	}
+
+	if err := r.load(); err != nil {
+		r.logger.Error("failed-to-reload", err)
+		r.incrementCounter(ReloadFailedCounter)
+		event.Outcome, event.Reason = audit.OutcomeFailure, err.Error()
+		r.audit.Record(event)
+		return
+	}
+
+	notAfter := r.cert.Load().Leaf.NotAfter
+	r.logger.Info("reloaded", lager.Data{"not-after": notAfter})
+	r.incrementCounter(ReloadSucceededCounter)
+	event.Outcome = audit.OutcomeSuccess
+	event.Details["not_after"] = notAfter.UTC().Format(time.RFC3339)
+	r.audit.Record(event)
+}
+
+func (r *Reloader) stat() ([2]fileVersion, error) {
+	var versions [2]fileVersion
+	for i, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return versions, err
+		}
+		versions[i] = fileVersion{modTime: info.ModTime(), size: info.Size()}
+	}
+	return versions, nil
+}
+
+func (r *Reloader) load() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if r.clock.Now().After(leaf.NotAfter) {
+		return fmt.Errorf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339))
+	}
+	cert.Leaf = leaf
+
+	r.cert.Store(&cert)
+	return nil
+}
+
+func (r *Reloader) incrementCounter(name string) {
+	if err := r.metronClient.IncrementCounter(name); err != nil {
+		r.logger.Error("failed-to-emit-metric", err, lager.Data{"metric": name})
+	}
+}
@@ -0,0 +1,13 @@
+package certreloader_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestCertReloader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CertReloader Suite")
+}
@@ -0,0 +1,184 @@
+package certreloader_test
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/diego-logging-client/testhelpers"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/certreloader"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Reloader", func() {
+	const pollInterval = 10 * time.Second
+
+	var (
+		logger       *lagertest.TestLogger
+		fakeClock    *fakeclock.FakeClock
+		metronClient *testhelpers.FakeIngressClient
+		ca           *certtest.Authority
+		certDir      string
+		certFile     string
+		keyFile      string
+		reloader     *certreloader.Reloader
+		process      ifrit.Process
+		writes       int
+		auditEvents  chan audit.Event
+	)
+
+	writePair := func(cert *certtest.Certificate) {
+		certPEM, keyPEM, err := cert.CertificatePEMAndPrivateKey()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(certFile, certPEM, 0600)).To(Succeed())
+		Expect(os.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+
+		// make sure the change is noticed even on coarse grained file systems
+		writes++
+		modTime := time.Now().Add(time.Duration(writes) * time.Minute)
+		Expect(os.Chtimes(certFile, modTime, modTime)).To(Succeed())
+	}
+
+	buildPair := func(name string) *certtest.Certificate {
+		cert, err := ca.BuildSignedCertificate(name)
+		Expect(err).NotTo(HaveOccurred())
+		return cert
+	}
+
+	servedCommonName := func() string {
+		cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+		Expect(err).NotTo(HaveOccurred())
+		return cert.Leaf.Subject.CommonName
+	}
+
+	BeforeEach(func() {
+		var err error
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+		metronClient = new(testhelpers.FakeIngressClient)
+
+		ca, err = certtest.BuildCA("test-ca")
+		Expect(err).NotTo(HaveOccurred())
+
+		certDir = GinkgoT().TempDir()
+		certFile = filepath.Join(certDir, "cert.pem")
+		keyFile = filepath.Join(certDir, "key.pem")
+		writePair(buildPair("first"))
+
+		auditEvents = make(chan audit.Event, 10)
+		reloader, err = certreloader.New(logger, certFile, keyFile, pollInterval, fakeClock, metronClient,
+			certreloader.WithAuditRecorder(auditRecorderFunc(func(event audit.Event) { auditEvents <- event })))
+		Expect(err).NotTo(HaveOccurred())
+		process = ifrit.Invoke(reloader)
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	})
+
+	It("serves the initial pair", func() {
+		Expect(servedCommonName()).To(Equal("first"))
+	})
+
+	It("does not reload files that have not changed", func() {
+		fakeClock.WaitForWatcherAndIncrement(pollInterval)
+		Consistently(metronClient.IncrementCounterCallCount).Should(Equal(0))
+	})
+
+	It("passes its check until the certificate expires", func() {
+		Expect(reloader.Check()).To(Succeed())
+
+		fakeClock.Increment(100 * 365 * 24 * time.Hour)
+		Expect(reloader.Check()).To(MatchError(ContainSubstring("certificate expired at")))
+	})
+
+	Context("when the files are rotated", func() {
+		BeforeEach(func() {
+			writePair(buildPair("second"))
+			fakeClock.WaitForWatcherAndIncrement(pollInterval)
+		})
+
+		It("serves the new pair", func() {
+			Eventually(servedCommonName).Should(Equal("second"))
+		})
+
+		It("logs and counts the reload", func() {
+			Eventually(logger).Should(gbytes.Say("test.cert-reloader.reloaded"))
+			Eventually(metronClient.IncrementCounterCallCount).Should(Equal(1))
+			Expect(metronClient.IncrementCounterArgsForCall(0)).To(Equal(certreloader.ReloadSucceededCounter))
+		})
+
+		It("records the reload in the audit log", func() {
+			var event audit.Event
+			Eventually(auditEvents).Should(Receive(&event))
+			Expect(event.Type).To(Equal(audit.EventConfigReload))
+			Expect(event.Outcome).To(Equal(audit.OutcomeSuccess))
+			Expect(event.Details).To(HaveKeyWithValue("cert_file", certFile))
+			Expect(event.Details).To(HaveKey("not_after"))
+		})
+	})
+
+	Context("when the certificate does not match the key", func() {
+		BeforeEach(func() {
+			_, keyPEM, err := buildPair("other").CertificatePEMAndPrivateKey()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.WriteFile(keyFile, keyPEM, 0600)).To(Succeed())
+			fakeClock.WaitForWatcherAndIncrement(pollInterval)
+		})
+
+		It("keeps serving the previous pair", func() {
+			Eventually(logger).Should(gbytes.Say("test.cert-reloader.failed-to-reload"))
+			Expect(servedCommonName()).To(Equal("first"))
+		})
+
+		It("counts the failure once until the files change again", func() {
+			Eventually(metronClient.IncrementCounterCallCount).Should(Equal(1))
+			Expect(metronClient.IncrementCounterArgsForCall(0)).To(Equal(certreloader.ReloadFailedCounter))
+
+			fakeClock.WaitForWatcherAndIncrement(pollInterval)
+			Consistently(metronClient.IncrementCounterCallCount).Should(Equal(1))
+		})
+	})
+
+	Context("when the new certificate has expired", func() {
+		BeforeEach(func() {
+			expired, err := ca.BuildSignedCertificateWithExpiry("expired", time.Now().Add(-time.Hour))
+			Expect(err).NotTo(HaveOccurred())
+			writePair(expired)
+			fakeClock.WaitForWatcherAndIncrement(pollInterval)
+		})
+
+		It("keeps serving the previous pair", func() {
+			Eventually(logger).Should(gbytes.Say("test.cert-reloader.failed-to-reload"))
+			Expect(servedCommonName()).To(Equal("first"))
+		})
+
+		It("records the failed reload in the audit log", func() {
+			var event audit.Event
+			Eventually(auditEvents).Should(Receive(&event))
+			Expect(event.Outcome).To(Equal(audit.OutcomeFailure))
+			Expect(event.Reason).To(ContainSubstring("certificate expired at"))
+		})
+	})
+
+	Context("when the initial pair is invalid", func() {
+		It("returns an error", func() {
+			Expect(os.WriteFile(keyFile, []byte("not a key"), 0600)).To(Succeed())
+			_, err := certreloader.New(logger, certFile, keyFile, pollInterval, fakeClock, metronClient)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+type auditRecorderFunc func(audit.Event)
+
+func (f auditRecorderFunc) Record(event audit.Event) { f(event) }
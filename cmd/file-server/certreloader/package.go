@@ -0,0 +1 @@
+package certreloader // import "code.cloudfoundry.org/fileserver/cmd/file-server/certreloader"
@@ -2,13 +2,36 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"slices"
 
 	"code.cloudfoundry.org/debugserver"
 	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admin"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/authorization"
+	"code.cloudfoundry.org/fileserver/handlers/bearer"
+	"code.cloudfoundry.org/fileserver/handlers/hsts"
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	"code.cloudfoundry.org/fileserver/handlers/ipfilter"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
+	"github.com/pires/go-proxyproto"
 )
 
+// DefaultHSTSMaxAge is the max-age of the Strict-Transport-Security header
+// when the config does not set one.
+const DefaultHSTSMaxAge = 31536000
+
 type FileServerConfig struct {
 	ServerAddress   string `json:"server_address,omitempty"`
 	StaticDirectory string `json:"static_directory,omitempty"`
@@ -17,14 +40,47 @@ type FileServerConfig struct {
 	HTTPSListenAddr    string `json:"https_listen_addr"`
 	CertFile           string `json:"cert_file"`
 	KeyFile            string `json:"key_file"`
+	CAFile             string `json:"ca_file,omitempty"`
+	RequireClientCert  bool   `json:"require_client_cert,omitempty"`
+	HTTP3ListenAddr    string `json:"http3_listen_addr,omitempty"`
+
+	PlainHTTP redirect.Config `json:"plain_http"`
+	HSTS      hsts.Config     `json:"hsts"`
+
+	ProxyProtocolTrustedCIDRs []string              `json:"proxy_protocol_trusted_cidrs,omitempty"`
+	UnixSocketMode            server.UnixSocketMode `json:"unix_socket_mode,omitempty"`
+
+	server.TLSProfile
+	HTTP2 server.HTTP2Config `json:"http2"`
+	// IdleTimeoutSeconds applies to keep-alive connections of every protocol
+	// on server_address and https_listen_addr.
+	IdleTimeoutSeconds int `json:"idle_timeout_seconds,omitempty"`
+
+	SymlinkPolicy      static.SymlinkPolicy      `json:"symlink_policy,omitempty"`
+	IgnorePatterns     static.IgnorePatterns     `json:"ignore_patterns"`
+	CacheControl       static.CacheControlConfig `json:"cache_control"`
+	AuthorizationRules authorization.Rules       `json:"authorization_rules,omitempty"`
+	SignedURLKeys      signedurl.Keys            `json:"signed_url_keys,omitempty"`
+	BearerAuth         bearer.Config             `json:"bearer_auth"`
+	IPFilter           ipfilter.Config           `json:"ip_filter"`
+	AccessLog          accesslog.Config          `json:"access_log"`
+	Audit              audit.Config              `json:"audit"`
 
 	LoggregatorConfig loggingclient.Config `json:"loggregator"`
+	Prometheus        instrument.Config    `json:"prometheus"`
+	Tracing           tracing.Config       `json:"tracing"`
+	Admin             admin.Config         `json:"admin"`
+	AssetStats        assetstats.Config    `json:"asset_stats"`
 	debugserver.DebugServerConfig
 	lagerflags.LagerConfig
 }
 
 func NewFileServerConfig(configPath string) (FileServerConfig, error) {
-	fileServerConfig := FileServerConfig{}
+	fileServerConfig := FileServerConfig{
+		HSTS: hsts.Config{MaxAgeSeconds: DefaultHSTSMaxAge},
+		// cloned because decoding a json array reuses the slice's backing array
+		IgnorePatterns: slices.Clone(static.DefaultIgnorePatterns),
+	}
 
 	configFile, err := os.Open(configPath)
 	if err != nil {
@@ -39,5 +95,78 @@ func NewFileServerConfig(configPath string) (FileServerConfig, error) {
 		return FileServerConfig{}, err
 	}
 
+	err = fileServerConfig.Validate()
+	if err != nil {
+		return FileServerConfig{}, err
+	}
+
 	return fileServerConfig, nil
 }
+
+// Validate checks the settings that would otherwise only fail once requests
+// are served.
+func (c FileServerConfig) Validate() error {
+	for name, address := range map[string]string{
+		"server_address":         c.ServerAddress,
+		"https_listen_addr":      c.HTTPSListenAddr,
+		"prometheus.listen_addr": c.Prometheus.ListenAddr,
+		"admin.listen_addr":      c.Admin.ListenAddr,
+	} {
+		if address == "" {
+			continue
+		}
+		if err := server.ValidateAddress(address); err != nil {
+			return fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+
+	if len(c.ProxyProtocolTrustedCIDRs) > 0 {
+		if _, err := proxyproto.PolicyFromRanges(c.ProxyProtocolTrustedCIDRs, proxyproto.USE, proxyproto.REJECT); err != nil {
+			return fmt.Errorf("invalid proxy_protocol_trusted_cidrs: %w", err)
+		}
+	}
+
+	if c.IdleTimeoutSeconds < 0 {
+		return errors.New("idle_timeout_seconds must not be negative")
+	}
+
+	if c.RequireClientCert && c.CAFile == "" {
+		return errors.New("require_client_cert is set but no ca_file is configured")
+	}
+
+	if c.HTTP3ListenAddr != "" {
+		if !c.HTTPSServerEnabled {
+			return errors.New("http3_listen_addr requires the https server to be enabled")
+		}
+		if _, port, err := net.SplitHostPort(c.HTTP3ListenAddr); err != nil || port == "" {
+			return fmt.Errorf("invalid http3_listen_addr %q", c.HTTP3ListenAddr)
+		}
+	}
+
+	validators := []interface{ Validate() error }{
+		c.TLSProfile,
+		c.PlainHTTP,
+		c.HSTS,
+		c.HTTP2,
+		c.UnixSocketMode,
+		c.SymlinkPolicy,
+		c.IgnorePatterns,
+		c.CacheControl,
+		c.AuthorizationRules,
+		c.SignedURLKeys,
+		c.BearerAuth,
+		c.IPFilter,
+		c.AccessLog,
+		c.Prometheus,
+		c.Tracing,
+		c.Admin,
+		c.AssetStats,
+	}
+	for _, v := range validators {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -5,6 +5,20 @@ import (
 
 	"code.cloudfoundry.org/debugserver"
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admin"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/authorization"
+	"code.cloudfoundry.org/fileserver/handlers/bearer"
+	"code.cloudfoundry.org/fileserver/handlers/hsts"
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	"code.cloudfoundry.org/fileserver/handlers/ipfilter"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -23,6 +37,86 @@ var _ = Describe("Config", func() {
 			"https_listen_addr": "192.168.1.1:8443",
 			"cert_file": "/tmp/cert_file",
 			"key_file": "/tmp/key_file",
+			"ca_file": "/tmp/ca_file",
+			"require_client_cert": true,
+			"http3_listen_addr": "192.168.1.1:8443",
+
+			"plain_http": {"policy": "redirect", "status": 308, "host": "files.example.com"},
+			"hsts": {"max_age_seconds": 63072000, "include_subdomains": true, "preload": true},
+			"min_tls_version": "1.2",
+			"cipher_suites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"],
+			"curve_preferences": ["X25519", "CurveP256"],
+			"alpn": ["h2", "http/1.1"],
+
+			"http2": {
+				"h2c": true,
+				"max_concurrent_streams": 500,
+				"connection_window_size": 2097152,
+				"stream_window_size": 1048576,
+				"read_idle_timeout_seconds": 30
+			},
+			"idle_timeout_seconds": 120,
+
+			"proxy_protocol_trusted_cidrs": ["10.0.255.0/24"],
+			"unix_socket_mode": "0660",
+
+			"symlink_policy": "follow_within_root",
+			"ignore_patterns": [".*", "*.meta"],
+
+			"cache_control": {
+				"rules": [
+					{"pattern": "*.tgz", "max_age": 3600, "immutable": true}
+				],
+				"default": {"no_cache": true}
+			},
+
+			"authorization_rules": [
+				{"path_prefix": "/v1/static/segment-a/", "subjects": ["cell-a"], "organizational_units": ["segment-a"]}
+			],
+
+			"signed_url_keys": [
+				{"id": "key-1", "secret": "a-secret-that-is-long-enough-to-use"}
+			],
+
+			"bearer_auth": {
+				"jwks_file": "/tmp/jwks.json",
+				"issuer": "https://uaa.example.com/oauth/token",
+				"audience": "file_server",
+				"required_scopes": {"Static": ["file_server.read"]}
+			},
+
+			"ip_filter": {
+				"allow": ["10.0.0.0/16"],
+				"deny": ["10.0.99.0/24"],
+				"mounts": [{"path_prefix": "/v1/static/segment-a/", "allow": ["10.0.1.0/24"]}],
+				"trusted_proxies": ["10.0.255.0/24"],
+				"trust_x_forwarded_for": true
+			},
+
+			"access_log": {
+				"format": "combined",
+				"file": "/var/vcap/sys/log/file_server/access.log",
+				"max_size_mb": 50,
+				"max_backups": 3,
+				"success_sample_rate": 0.25
+			},
+			"audit": {"file": "/var/vcap/sys/log/file_server/audit.log"},
+
+			"prometheus": {"enabled": true, "listen_addr": "127.0.0.1:9100"},
+			"tracing": {
+				"endpoint": "otel-collector:4318",
+				"protocol": "http/protobuf",
+				"sample_ratio": 0.05,
+				"resource_attributes": {"deployment.environment": "cf"}
+			},
+			"admin": {
+				"listen_addr": "127.0.0.1:8090",
+				"cert_file": "/var/vcap/jobs/file_server/config/admin.crt",
+				"key_file": "/var/vcap/jobs/file_server/config/admin.key",
+				"ca_file": "/var/vcap/jobs/file_server/config/admin_ca.crt",
+				"subjects": ["operator"]
+			},
+			"asset_stats": {"enabled": true, "flush_interval_seconds": 3600},
 
 			"debug_address": "127.0.0.1:17017",
 			"log_level": "debug"
@@ -49,6 +143,7 @@ var _ = Describe("Config", func() {
 		fileserverConfig, err := config.NewFileServerConfig(configPath)
 		Expect(err).NotTo(HaveOccurred())
 
+		maxAge := 3600
 		expectedConfig := config.FileServerConfig{
 			ServerAddress:   "192.168.1.1:8080",
 			StaticDirectory: "/tmp/static",
@@ -57,6 +152,86 @@ var _ = Describe("Config", func() {
 			HTTPSListenAddr:    "192.168.1.1:8443",
 			CertFile:           "/tmp/cert_file",
 			KeyFile:            "/tmp/key_file",
+			CAFile:             "/tmp/ca_file",
+			RequireClientCert:  true,
+			HTTP3ListenAddr:    "192.168.1.1:8443",
+
+			PlainHTTP: redirect.Config{Policy: redirect.PolicyRedirect, Status: 308, Host: "files.example.com"},
+			HSTS:      hsts.Config{MaxAgeSeconds: 63072000, IncludeSubDomains: true, Preload: true},
+			TLSProfile: server.TLSProfile{
+				MinTLSVersion:    "1.2",
+				CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+				CurvePreferences: []string{"X25519", "CurveP256"},
+				ALPN:             []string{"h2", "http/1.1"},
+			},
+			HTTP2: server.HTTP2Config{
+				H2C:                    true,
+				MaxConcurrentStreams:   500,
+				ConnectionWindowSize:   2097152,
+				StreamWindowSize:       1048576,
+				ReadIdleTimeoutSeconds: 30,
+			},
+			IdleTimeoutSeconds: 120,
+
+			ProxyProtocolTrustedCIDRs: []string{"10.0.255.0/24"},
+			UnixSocketMode:            "0660",
+
+			SymlinkPolicy:  static.SymlinkFollowWithinRoot,
+			IgnorePatterns: static.IgnorePatterns{".*", "*.meta"},
+			CacheControl: static.CacheControlConfig{
+				Rules: []static.CacheControlRule{
+					{Pattern: "*.tgz", MaxAge: &maxAge, Immutable: true},
+				},
+				Default: &static.CacheControlRule{NoCache: true},
+			},
+			AuthorizationRules: authorization.Rules{
+				{PathPrefix: "/v1/static/segment-a/", Subjects: []string{"cell-a"}, OrganizationalUnits: []string{"segment-a"}},
+			},
+			SignedURLKeys: signedurl.Keys{
+				{ID: "key-1", Secret: "a-secret-that-is-long-enough-to-use"},
+			},
+			BearerAuth: bearer.Config{
+				JWKSFile:       "/tmp/jwks.json",
+				Issuer:         "https://uaa.example.com/oauth/token",
+				Audience:       "file_server",
+				RequiredScopes: map[string][]string{"Static": {"file_server.read"}},
+			},
+			IPFilter: ipfilter.Config{
+				Rules: ipfilter.Rules{
+					Allow: []string{"10.0.0.0/16"},
+					Deny:  []string{"10.0.99.0/24"},
+				},
+				Mounts: []ipfilter.Mount{
+					{PathPrefix: "/v1/static/segment-a/", Rules: ipfilter.Rules{Allow: []string{"10.0.1.0/24"}}},
+				},
+				TrustedProxies:     []string{"10.0.255.0/24"},
+				TrustXForwardedFor: true,
+			},
+
+			AccessLog: accesslog.Config{
+				Format:            accesslog.FormatCombined,
+				File:              "/var/vcap/sys/log/file_server/access.log",
+				MaxSizeMB:         50,
+				MaxBackups:        3,
+				SuccessSampleRate: 0.25,
+			},
+			Audit: audit.Config{File: "/var/vcap/sys/log/file_server/audit.log"},
+
+			Prometheus: instrument.Config{Enabled: true, ListenAddr: "127.0.0.1:9100"},
+			Tracing: tracing.Config{
+				Endpoint:           "otel-collector:4318",
+				Protocol:           tracing.ProtocolHTTP,
+				SampleRatio:        0.05,
+				ResourceAttributes: map[string]string{"deployment.environment": "cf"},
+			},
+			Admin: admin.Config{
+				ListenAddr: "127.0.0.1:8090",
+				CertFile:   "/var/vcap/jobs/file_server/config/admin.crt",
+				KeyFile:    "/var/vcap/jobs/file_server/config/admin.key",
+				CAFile:     "/var/vcap/jobs/file_server/config/admin_ca.crt",
+				Subjects:   []string{"operator"},
+			},
+			AssetStats: assetstats.Config{Enabled: true, FlushIntervalSeconds: 3600},
 
 			DebugServerConfig: debugserver.DebugServerConfig{
 				DebugAddress: "127.0.0.1:17017",
@@ -76,6 +251,98 @@ var _ = Describe("Config", func() {
 		})
 	})
 
+	Context("when hsts is not configured", func() {
+		BeforeEach(func() {
+			configData = `{}`
+		})
+
+		It("sends the header with the default max age", func() {
+			fileserverConfig, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileserverConfig.HSTS).To(Equal(hsts.Config{MaxAgeSeconds: config.DefaultHSTSMaxAge}))
+		})
+	})
+
+	Context("when the plain http policy is unknown", func() {
+		BeforeEach(func() {
+			configData = `{"plain_http": {"policy": "sometimes"}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid plain http policy")))
+		})
+	})
+
+	Context("when no ignore patterns are configured", func() {
+		BeforeEach(func() {
+			configData = `{"static_directory": "/tmp/static"}`
+		})
+
+		It("hides dotfiles and temporary files by default", func() {
+			fileserverConfig, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileserverConfig.IgnorePatterns).To(Equal(static.DefaultIgnorePatterns))
+		})
+	})
+
+	Context("when ignore patterns are explicitly empty", func() {
+		BeforeEach(func() {
+			configData = `{"ignore_patterns": []}`
+		})
+
+		It("does not ignore anything", func() {
+			fileserverConfig, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileserverConfig.IgnorePatterns).To(BeEmpty())
+			Expect(static.DefaultIgnorePatterns).NotTo(BeEmpty())
+		})
+	})
+
+	Context("when an ignore pattern is invalid", func() {
+		BeforeEach(func() {
+			configData = `{"ignore_patterns": ["[a-"]}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid ignore pattern")))
+		})
+	})
+
+	Context("when the tls profile is unsafe", func() {
+		BeforeEach(func() {
+			configData = `{"min_tls_version": "1.0"}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("min_tls_version")))
+		})
+	})
+
+	Context("when an http2 window size is too small", func() {
+		BeforeEach(func() {
+			configData = `{"http2": {"stream_window_size": 1024}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("stream_window_size")))
+		})
+	})
+
+	Context("when http3 is enabled without the https server", func() {
+		BeforeEach(func() {
+			configData = `{"http3_listen_addr": "127.0.0.1:8443"}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("http3_listen_addr requires the https server")))
+		})
+	})
+
 	Context("when the file does not contain valid json", func() {
 		BeforeEach(func() {
 			configData = "{{"
@@ -86,4 +353,204 @@ var _ = Describe("Config", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("when a cache control pattern is invalid", func() {
+		BeforeEach(func() {
+			configData = `{"cache_control": {"rules": [{"pattern": "[", "max_age": 60}]}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid cache control pattern")))
+		})
+	})
+
+	Context("when a cache control rule is both no-cache and immutable", func() {
+		BeforeEach(func() {
+			configData = `{"cache_control": {"default": {"no_cache": true, "immutable": true}}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when a cache control rule sets no directives", func() {
+		BeforeEach(func() {
+			configData = `{"cache_control": {"rules": [{"pattern": "*.tgz"}]}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring(`cache control rule "*.tgz" sets no directives`)))
+		})
+	})
+
+	Context("when a cache control rule only sets a max age of 0", func() {
+		BeforeEach(func() {
+			configData = `{"cache_control": {"rules": [{"pattern": "*.json", "max_age": 0}]}}`
+		})
+
+		It("keeps it", func() {
+			fileserverConfig, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fileserverConfig.CacheControl.Rules[0].MaxAge).To(HaveValue(Equal(0)))
+		})
+	})
+
+	Context("when an authorization rule does not allow any identity", func() {
+		BeforeEach(func() {
+			configData = `{"authorization_rules": [{"path_prefix": "/v1/static/segment-a/"}]}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when a signed url key is too short", func() {
+		BeforeEach(func() {
+			configData = `{"signed_url_keys": [{"id": "key-1", "secret": "short"}]}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when an ip filter entry is invalid", func() {
+		BeforeEach(func() {
+			configData = `{"ip_filter": {"allow": ["10.0.0.0/33"]}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid cidr")))
+		})
+	})
+
+	Context("when a proxy protocol trusted cidr is invalid", func() {
+		BeforeEach(func() {
+			configData = `{"proxy_protocol_trusted_cidrs": ["not-a-cidr"]}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid proxy_protocol_trusted_cidrs")))
+		})
+	})
+
+	Context("when the server address is a unix socket", func() {
+		BeforeEach(func() {
+			configData = `{"server_address": "unix:///var/vcap/data/fileserver/fileserver.sock"}`
+		})
+
+		It("accepts it", func() {
+			cfg, err := config.NewFileServerConfig(configPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cfg.ServerAddress).To(Equal("unix:///var/vcap/data/fileserver/fileserver.sock"))
+		})
+	})
+
+	Context("when the https listen address is invalid", func() {
+		BeforeEach(func() {
+			configData = `{"https_listen_addr": "unix://fileserver.sock"}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid https_listen_addr")))
+		})
+	})
+
+	Context("when the unix socket mode is not octal", func() {
+		BeforeEach(func() {
+			configData = `{"unix_socket_mode": "rw-rw----"}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid unix_socket_mode")))
+		})
+	})
+
+	Context("when client certificates are required without a CA", func() {
+		BeforeEach(func() {
+			configData = `{"https_server_enabled": true, "require_client_cert": true}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("require_client_cert is set but no ca_file is configured")))
+		})
+	})
+
+	Context("when the admin api has no CA", func() {
+		BeforeEach(func() {
+			configData = `{"admin": {"listen_addr": "127.0.0.1:8090", "cert_file": "admin.crt", "key_file": "admin.key"}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("admin api requires")))
+		})
+	})
+
+	Context("when asset stats are flushed without being enabled", func() {
+		BeforeEach(func() {
+			configData = `{"asset_stats": {"flush_interval_seconds": 60}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("requires asset_stats to be enabled")))
+		})
+	})
+
+	Context("when the prometheus listen address is set while prometheus is disabled", func() {
+		BeforeEach(func() {
+			configData = `{"prometheus": {"listen_addr": "127.0.0.1:9100"}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("prometheus is not enabled")))
+		})
+	})
+
+	Context("when the access log format has no destination", func() {
+		BeforeEach(func() {
+			configData = `{"access_log": {"format": "json"}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("requires either a file or syslog")))
+		})
+	})
+
+	Context("when the tracing sample ratio is out of range", func() {
+		BeforeEach(func() {
+			configData = `{"tracing": {"endpoint": "otel-collector:4317", "sample_ratio": 2}}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("tracing sample_ratio")))
+		})
+	})
+
+	Context("when the symlink policy is unknown", func() {
+		BeforeEach(func() {
+			configData = `{"symlink_policy": "sometimes"}`
+		})
+
+		It("returns an error", func() {
+			_, err := config.NewFileServerConfig(configPath)
+			Expect(err).To(MatchError(ContainSubstring("invalid symlink policy")))
+		})
+	})
 })
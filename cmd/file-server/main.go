@@ -2,16 +2,32 @@ package main
 
 import (
 	"crypto/tls"
+	"errors"
 	"flag"
 	"net/http"
 	"os"
 	"runtime"
-	"strings"
+	"slices"
+	"time"
 
+	"code.cloudfoundry.org/clock"
 	"code.cloudfoundry.org/debugserver"
 	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/certreloader"
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
 	"code.cloudfoundry.org/fileserver/handlers"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admin"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/bearer"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/hsts"
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
 	"code.cloudfoundry.org/go-loggregator/v9/runtimeemitter"
 	"code.cloudfoundry.org/lager/v3"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
@@ -29,7 +45,12 @@ var configFilePath = flag.String(
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == signURLCommand {
+		os.Exit(signURL(os.Args[2:]))
+	}
+
 	runtime.GOMAXPROCS(runtime.NumCPU())
+	debugserver.AddFlags(flag.CommandLine)
 	flag.Parse()
 	cfg, err := config.NewFileServerConfig(*configFilePath)
 	if err != nil {
@@ -39,31 +60,134 @@ func main() {
 
 	logger, reconfigurableSink := lagerflags.NewFromConfig("file-server", cfg.LagerConfig)
 
-	_, err = initializeMetron(logger, cfg)
+	metronClient, err := initializeMetron(logger, cfg)
 	if err != nil {
 		logger.Error("failed-to-initialize-metron-client", err)
 		os.Exit(1)
 	}
 
-	var tlsConfig *tls.Config
+	auditRecorder := audit.Discard
+	if cfg.Audit.Enabled() {
+		auditLog, err := audit.New(logger, cfg.Audit)
+		if err != nil {
+			logger.Fatal("failed-to-open-audit-log", err)
+		}
+		auditRecorder = auditLog
+	}
+
+	var (
+		tlsConfig       *tls.Config
+		certReloader    *certreloader.Reloader
+		readinessChecks []health.Check
+	)
 	if cfg.HTTPSServerEnabled {
 		if len(cfg.HTTPSListenAddr) == 0 {
 			logger.Fatal("invalid-https-configuration", nil)
 		}
+		var serverOpts []tlsconfig.ServerOption
+		if cfg.CAFile != "" {
+			serverOpts = append(serverOpts, tlsconfig.WithClientAuthenticationFromFile(cfg.CAFile))
+		}
+
 		var err error
+		certReloader, err = certreloader.New(logger, cfg.CertFile, cfg.KeyFile, certreloader.DefaultPollInterval, clock.NewClock(), metronClient, certreloader.WithAuditRecorder(auditRecorder))
+		if err != nil {
+			logger.Fatal("failed-to-create-tls-config", err)
+		}
+
 		tlsConfig, err = tlsconfig.Build(
 			tlsconfig.WithInternalServiceDefaults(),
-			tlsconfig.WithIdentityFromFile(cfg.CertFile, cfg.KeyFile),
-		).Server()
+			cfg.TLSProfile.Apply,
+		).Server(serverOpts...)
 		if err != nil {
 			logger.Fatal("failed-to-create-tls-config", err)
 		}
+		tlsConfig.GetCertificate = certReloader.GetCertificate
+		readinessChecks = append(readinessChecks, health.Check{Name: "tls-certificate", Run: certReloader.Check})
+
+		if cfg.CAFile != "" && !cfg.RequireClientCert {
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+	var metrics *instrument.Metrics
+	if cfg.Prometheus.Enabled {
+		metrics = instrument.New()
+	}
+
+	var tracingProvider *tracing.Provider
+	if cfg.Tracing.Enabled() {
+		tracingProvider, err = tracing.NewProvider(cfg.Tracing)
+		if err != nil {
+			logger.Fatal("failed-to-create-tracing-exporter", err)
+		}
 	}
+
+	var assetStats *assetstats.Stats
+	if cfg.AssetStats.Enabled {
+		assetStats = assetstats.New()
+	}
+
+	digests := static.NewDigestCache()
 	members := grouper.Members{
-		{Name: "file server", Runner: initializeServer(logger, cfg.StaticDirectory, cfg.ServerAddress, cfg.HTTPSListenAddr, tlsConfig)},
+		{Name: "file server", Runner: initializeServer(logger, cfg, tlsConfig, metronClient, metrics, tracingProvider, readinessChecks, digests, auditRecorder, assetStats)},
+	}
+	if cfg.Admin.Enabled() {
+		members = append(members, grouper.Member{
+			Name:   "admin-server",
+			Runner: initializeAdminServer(logger, cfg.Admin, digests, auditRecorder, assetStats),
+		})
+	}
+	if cfg.AssetStats.FlushIntervalSeconds > 0 {
+		// started first so the requests the servers drain are in the last flush
+		flushInterval := time.Duration(cfg.AssetStats.FlushIntervalSeconds) * time.Second
+		members = append(grouper.Members{
+			{Name: "asset-stats-flusher", Runner: assetstats.NewFlusher(logger, assetStats, flushInterval, clock.NewClock())},
+		}, members...)
+	}
+	if tracingProvider != nil {
+		// ordered members stop in reverse, so the spans of the requests the
+		// servers drain are still flushed
+		members = append(grouper.Members{
+			{Name: "tracing", Runner: tracingProvider},
+		}, members...)
 	}
 
-	if dbgAddr := debugserver.DebugAddress(flag.CommandLine); dbgAddr != "" {
+	dbgAddr := debugserver.DebugAddress(flag.CommandLine)
+	if metrics != nil {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(instrument.MetricsPath, metrics.Handler())
+
+		switch {
+		case cfg.Prometheus.ListenAddr != "":
+			members = append(members, grouper.Member{
+				Name:   "metrics-server",
+				Runner: server.New(logger, cfg.Prometheus.ListenAddr, metricsMux),
+			})
+		case dbgAddr != "":
+			// the debug server's own routes are served next to /metrics
+			metricsMux.Handle("/", debugserver.Handler(reconfigurableSink))
+			members = append(grouper.Members{
+				{Name: "debug-server", Runner: http_server.New(dbgAddr, metricsMux)},
+			}, members...)
+			dbgAddr = ""
+		case cfg.DebugAddress != "":
+			// the debug routes are only served when the flag asks for them
+			members = append(members, grouper.Member{
+				Name:   "metrics-server",
+				Runner: server.New(logger, cfg.DebugAddress, metricsMux),
+			})
+		default:
+			logger.Fatal("invalid-prometheus-configuration", errors.New("prometheus is enabled but neither a listen_addr nor a debug server is configured"))
+		}
+	}
+
+	if certReloader != nil {
+		members = append(grouper.Members{
+			{Name: "cert-reloader", Runner: certReloader},
+		}, members...)
+	}
+
+	if dbgAddr != "" {
 		members = append(grouper.Members{
 			{Name: "debug-server", Runner: debugserver.Runner(dbgAddr, reconfigurableSink)},
 		}, members...)
@@ -97,31 +221,154 @@ func initializeMetron(logger lager.Logger, config config.FileServerConfig) (logg
 	return client, nil
 }
 
-func initializeServer(logger lager.Logger, staticDirectory, serverAddress, serverAddressTls string, tlsConfig *tls.Config) ifrit.Runner {
-	if staticDirectory == "" {
+func initializeAdminServer(logger lager.Logger, cfg admin.Config, digests *static.DigestCache, auditRecorder audit.Recorder, assetStats *assetstats.Stats) ifrit.Runner {
+	tlsConfig, err := tlsconfig.Build(
+		tlsconfig.WithInternalServiceDefaults(),
+		tlsconfig.WithIdentityFromFile(cfg.CertFile, cfg.KeyFile),
+	).Server(tlsconfig.WithClientAuthenticationFromFile(cfg.CAFile))
+	if err != nil {
+		logger.Fatal("failed-to-create-admin-tls-config", err)
+	}
+
+	var adminOpts []admin.Option
+	if assetStats != nil {
+		adminOpts = append(adminOpts, admin.WithAssetStats(assetStats))
+	}
+	handler, err := admin.New(logger, cfg, digests, adminOpts...)
+	if err != nil {
+		logger.Fatal("failed-to-create-admin-handler", err)
+	}
+
+	return server.NewTLS(logger, cfg.ListenAddr, audit.Handler(auditRecorder, handler), tlsConfig, server.WithAuditRecorder(auditRecorder))
+}
+
+func initializeServer(
+	logger lager.Logger,
+	cfg config.FileServerConfig,
+	tlsConfig *tls.Config,
+	metronClient loggingclient.IngressClient,
+	metrics *instrument.Metrics,
+	tracingProvider *tracing.Provider,
+	readinessChecks []health.Check,
+	digests *static.DigestCache,
+	auditRecorder audit.Recorder,
+	assetStats *assetstats.Stats,
+) ifrit.Runner {
+	if cfg.StaticDirectory == "" {
 		logger.Fatal("static-directory-missing", nil)
 	}
 
-	fileServerHandler, err := handlers.New(staticDirectory, logger)
+	accessLogger, err := accesslog.New(logger, cfg.AccessLog)
+	if err != nil {
+		logger.Fatal("failed-to-open-access-log", err)
+	}
+
+	realClock := clock.NewClock()
+	handlerOpts := []handlers.Option{
+		handlers.WithAccessLogger(accessLogger),
+		handlers.WithStaticOptions(
+			static.WithDigestCache(digests),
+			static.WithSymlinkPolicy(cfg.SymlinkPolicy),
+			static.WithIgnorePatterns(cfg.IgnorePatterns),
+			static.WithCacheControl(cfg.CacheControl),
+		),
+		handlers.WithAuthorizationRules(cfg.AuthorizationRules),
+		handlers.WithSignedURLs(cfg.SignedURLKeys, realClock),
+		handlers.WithIPFilter(cfg.IPFilter),
+		handlers.WithMetronClient(metronClient),
+		handlers.WithReadinessChecks(readinessChecks...),
+		handlers.WithAuditRecorder(auditRecorder),
+	}
+	if assetStats != nil {
+		handlerOpts = append(handlerOpts, handlers.WithStaticOptions(static.WithAssetObserver(assetStats)))
+	}
+	if cfg.AccessLog.IncludeHealthChecks {
+		handlerOpts = append(handlerOpts, handlers.WithHealthCheckLogging())
+	}
+	if metrics != nil {
+		handlerOpts = append(handlerOpts, handlers.WithPrometheusMetrics(metrics))
+	}
+	if tracingProvider != nil {
+		handlerOpts = append(handlerOpts, handlers.WithTracerProvider(tracingProvider.TracerProvider()))
+	}
+
+	if cfg.BearerAuth.Enabled() {
+		keySet, err := bearer.NewKeySet(logger, cfg.BearerAuth.JWKSFile, bearer.DefaultRefreshInterval, realClock, bearer.WithAuditRecorder(auditRecorder))
+		if err != nil {
+			logger.Fatal("failed-to-load-jwks", err)
+		}
+		verifier := bearer.NewVerifier(keySet, cfg.BearerAuth.Issuer, cfg.BearerAuth.Audience, realClock)
+		handlerOpts = append(handlerOpts, handlers.WithBearerAuth(verifier, cfg.BearerAuth.RequiredScopes))
+	}
+
+	fileServerHandler, err := handlers.New(cfg.StaticDirectory, logger, handlerOpts...)
 	if err != nil {
 		logger.Error("router-building-failed", err)
 		os.Exit(1)
 	}
 
+	unixSocketMode, err := cfg.UnixSocketMode.FileMode()
+	if err != nil {
+		logger.Fatal("invalid-unix-socket-mode", err)
+	}
+
+	// shared by every listener on server_address and https_listen_addr
+	listenerOpts := []server.Option{
+		server.WithUnixSocketMode(unixSocketMode),
+		server.WithIdleTimeout(time.Duration(cfg.IdleTimeoutSeconds) * time.Second),
+	}
+	if len(cfg.ProxyProtocolTrustedCIDRs) > 0 {
+		listenerOpts = append(listenerOpts, server.WithProxyProtocol(cfg.ProxyProtocolTrustedCIDRs))
+	}
+	serverOpts := append([]server.Option{
+		server.WithHTTP2(cfg.HTTP2),
+		server.WithAuditRecorder(auditRecorder),
+	}, listenerOpts...)
+	withConnState := func(listener string, opts []server.Option) []server.Option {
+		if metrics == nil {
+			return opts
+		}
+		return append(slices.Clip(opts), server.WithConnState(metrics.ConnState(listener)))
+	}
+
 	if tlsConfig != nil {
-		return grouper.NewParallel(os.Interrupt, grouper.Members{
-			{Name: "tls-server", Runner: http_server.NewTLSServer(serverAddressTls, fileServerHandler, tlsConfig)},
-			{
-				Name: "redirect-server",
-				Runner: http_server.New(serverAddress, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-					httpHostPort := strings.Split(r.Host, ":")
-					tlsHostPort := strings.Split(serverAddressTls, ":")
-					httpsHost := httpHostPort[0] + ":" + tlsHostPort[1]
-					http.Redirect(w, r, "https://"+httpsHost+r.URL.String(), http.StatusMovedPermanently)
-				})),
-			},
-		})
+		tlsServerOpts := withConnState("https", serverOpts)
+		if cfg.HTTP3ListenAddr != "" {
+			tlsServerOpts = append(tlsServerOpts, server.WithAltSvc(cfg.HTTP3ListenAddr))
+		}
+
+		tlsHandler := hsts.New(cfg.HSTS, fileServerHandler)
+		members := grouper.Members{
+			{Name: "tls-server", Runner: server.NewTLS(logger, cfg.HTTPSListenAddr, tlsHandler, tlsConfig, tlsServerOpts...)},
+		}
+
+		switch cfg.PlainHTTP.Policy {
+		case redirect.PolicyDisabled:
+		case redirect.PolicyServe:
+			members = append(members, grouper.Member{
+				Name:   "http-server",
+				Runner: server.New(logger, cfg.ServerAddress, fileServerHandler, withConnState("http", serverOpts)...),
+			})
+		default:
+			redirectHandler, err := redirect.New(cfg.PlainHTTP, cfg.HTTPSListenAddr)
+			if err != nil {
+				logger.Fatal("invalid-https-configuration", err)
+			}
+			members = append(members, grouper.Member{
+				Name:   "redirect-server",
+				Runner: server.New(logger, cfg.ServerAddress, redirectHandler, withConnState("http", listenerOpts)...),
+			})
+		}
+
+		if cfg.HTTP3ListenAddr != "" {
+			members = append(members, grouper.Member{
+				Name:   "http3-server",
+				Runner: server.NewHTTP3(logger, cfg.HTTP3ListenAddr, tlsHandler, tlsConfig),
+			})
+		}
+
+		return grouper.NewParallel(os.Interrupt, members)
 	}
 
-	return http_server.New(serverAddress, fileServerHandler)
+	return server.New(logger, cfg.ServerAddress, fileServerHandler, withConnState("http", serverOpts)...)
 }
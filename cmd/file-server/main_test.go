@@ -1,18 +1,33 @@
 package main_test
 
 import (
+	"bufio"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/fileserver/handlers/admin"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/authorization"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/hsts"
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
 	"code.cloudfoundry.org/lager/v3/lagerflags"
 	"code.cloudfoundry.org/tlsconfig"
 	"code.cloudfoundry.org/tlsconfig/certtest"
@@ -20,6 +35,7 @@ import (
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 	"github.com/onsi/gomega/gexec"
+	"github.com/quic-go/quic-go/http3"
 )
 
 var _ = Describe("File server", func() {
@@ -30,11 +46,21 @@ var _ = Describe("File server", func() {
 		err             error
 		configPath      string
 		cfg             config.FileServerConfig
+		inheritedFiles  []*os.File
+		inheritedEnv    []string
 	)
 
+	BeforeEach(func() {
+		inheritedFiles = nil
+		inheritedEnv = nil
+	})
+
 	start := func(extras ...string) *gexec.Session {
-		args := []string{"-config", configPath}
-		session, err = gexec.Start(exec.Command(fileServerBinary, args...), GinkgoWriter, GinkgoWriter)
+		args := append([]string{"-config", configPath}, extras...)
+		command := exec.Command(fileServerBinary, args...)
+		command.ExtraFiles = inheritedFiles
+		command.Env = append(os.Environ(), inheritedEnv...)
+		session, err = gexec.Start(command, GinkgoWriter, GinkgoWriter)
 		Expect(err).NotTo(HaveOccurred())
 
 		Eventually(session).Should(gbytes.Say("file-server.ready"))
@@ -58,7 +84,10 @@ var _ = Describe("File server", func() {
 	})
 
 	Context("when started correctly", func() {
+		var flags []string
+
 		BeforeEach(func() {
+			flags = nil
 			servedDirectory, err = os.MkdirTemp("", "file_server-test")
 			Expect(err).NotTo(HaveOccurred())
 
@@ -82,7 +111,7 @@ var _ = Describe("File server", func() {
 			err = encoder.Encode(&cfg)
 			Expect(err).NotTo(HaveOccurred())
 
-			session = start()
+			session = start(flags...)
 			os.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)
 		})
 
@@ -99,6 +128,471 @@ var _ = Describe("File server", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(string(body)).To(Equal("hello"))
 		})
+
+		It("echoes the request ID and logs it", func() {
+			request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/v1/static/test", port), nil)
+			Expect(err).NotTo(HaveOccurred())
+			request.Header.Set("X-Request-Id", "cell-1-download")
+			resp, err := http.DefaultClient.Do(request)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(resp.Header.Get("X-Request-Id")).To(Equal("cell-1-download"))
+			Expect(resp.Header.Get("Traceparent")).To(MatchRegexp(`^00-[0-9a-f]{32}-[0-9a-f]{16}-00$`))
+			Eventually(session.Out).Should(gbytes.Say(`"request-id":"cell-1-download"`))
+		})
+
+		It("answers health probes without logging them", func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/healthz", port))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			resp, err = http.Get(fmt.Sprintf("http://localhost:%d/readyz", port))
+			Expect(err).NotTo(HaveOccurred())
+			var report health.Report
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(report.Checks).To(HaveKeyWithValue("static-directory", health.CheckResult{Status: health.StatusOK}))
+
+			resp, err = http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Eventually(session.Out).Should(gbytes.Say(`"uri":"/v1/static/test"`))
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring(`"uri":"/healthz"`))
+			Expect(string(session.Out.Contents())).NotTo(ContainSubstring(`"uri":"/readyz"`))
+		})
+
+		It("is not ready once the static directory is gone", func() {
+			Expect(os.RemoveAll(servedDirectory)).To(Succeed())
+
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/readyz", port))
+			Expect(err).NotTo(HaveOccurred())
+			var report health.Report
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusServiceUnavailable))
+			Expect(report.Status).To(Equal(health.StatusFailed))
+			Expect(report.Checks).To(HaveKeyWithValue("static-directory", health.CheckResult{Status: health.StatusFailed}))
+			Eventually(session.Out).Should(gbytes.Say("file-server.readiness.check-failed.*no such file or directory"))
+		})
+
+		h2cClient := func() *http.Client {
+			protocols := new(http.Protocols)
+			protocols.SetUnencryptedHTTP2(true)
+			return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		}
+
+		It("does not speak HTTP/2 on the plain listener by default", func() {
+			_, err := h2cClient().Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when h2c is enabled", func() {
+			BeforeEach(func() {
+				cfg.HTTP2 = server.HTTP2Config{H2C: true, MaxConcurrentStreams: 10}
+			})
+
+			It("serves HTTP/2 with prior knowledge", func() {
+				resp, err := h2cClient().Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Proto).To(Equal("HTTP/2.0"))
+			})
+
+			It("still serves HTTP/1.1", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Proto).To(Equal("HTTP/1.1"))
+			})
+		})
+
+		Context("when the access log is written to a file", func() {
+			var accessLogPath string
+
+			BeforeEach(func() {
+				accessLogPath = filepath.Join(GinkgoT().TempDir(), "access.log")
+				cfg.AccessLog = accesslog.Config{Format: accesslog.FormatCombined, File: accessLogPath}
+			})
+
+			It("writes combined log lines there", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				Eventually(func() (string, error) {
+					contents, err := os.ReadFile(accessLogPath)
+					return string(contents), err
+				}).Should(ContainSubstring(`"GET /v1/static/test HTTP/1.1" 200 5`))
+				Expect(session.Out).NotTo(gbytes.Say("static-file.response"))
+			})
+
+			Context("when health checks are included", func() {
+				BeforeEach(func() {
+					cfg.AccessLog.IncludeHealthChecks = true
+				})
+
+				It("logs the probes too", func() {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/readyz", port))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+
+					Eventually(func() (string, error) {
+						contents, err := os.ReadFile(accessLogPath)
+						return string(contents), err
+					}).Should(ContainSubstring(`"GET /readyz HTTP/1.1" 200`))
+				})
+			})
+		})
+
+		Context("when the audit log is enabled", func() {
+			var auditLogPath string
+
+			BeforeEach(func() {
+				auditLogPath = filepath.Join(GinkgoT().TempDir(), "audit.log")
+				cfg.Audit = audit.Config{File: auditLogPath}
+			})
+
+			It("records requests for directories as JSON lines", func() {
+				Expect(os.Mkdir(filepath.Join(servedDirectory, "dir"), 0755)).To(Succeed())
+				request, err := http.NewRequest("GET", fmt.Sprintf("http://localhost:%d/v1/static/dir", port), nil)
+				Expect(err).NotTo(HaveOccurred())
+				request.Header.Set("X-Request-Id", "probe-1")
+				resp, err := http.DefaultClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+
+				contents, err := os.ReadFile(auditLogPath)
+				Expect(err).NotTo(HaveOccurred())
+				var event audit.Event
+				Expect(json.Unmarshal(contents, &event)).To(Succeed())
+				Expect(event.Type).To(Equal(audit.EventDirectoryAccess))
+				Expect(event.URI).To(Equal("/v1/static/dir"))
+				Expect(event.RequestID).To(Equal("probe-1"))
+			})
+		})
+
+		Context("when prometheus has its own listener", func() {
+			var metricsPort int
+
+			BeforeEach(func() {
+				metricsPort = 8482 + GinkgoParallelProcess()
+				cfg.Prometheus = instrument.Config{Enabled: true, ListenAddr: fmt.Sprintf("127.0.0.1:%d", metricsPort)}
+			})
+
+			It("exposes request and connection metrics", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", metricsPort))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(string(body)).To(ContainSubstring(`fileserver_http_requests_total{code="200",route="Static"} 1`))
+				Expect(string(body)).To(ContainSubstring(`fileserver_bytes_served_total{path="/test"} 5`))
+				Expect(string(body)).To(ContainSubstring(`fileserver_open_connections{listener="http"}`))
+				Expect(string(body)).To(ContainSubstring("fileserver_sha_cache_entries 1"))
+			})
+		})
+
+		Context("when the admin api is enabled", func() {
+			var (
+				adminPort   int
+				adminClient *http.Client
+			)
+
+			BeforeEach(func() {
+				adminPort = 8582 + GinkgoParallelProcess()
+				dir := GinkgoT().TempDir()
+
+				ca, err := certtest.BuildCA("admin-ca")
+				Expect(err).NotTo(HaveOccurred())
+				caPEM, err := ca.CertificatePEM()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(dir, "ca.crt"), caPEM, 0600)).To(Succeed())
+
+				serverCert, err := ca.BuildSignedCertificate("admin")
+				Expect(err).NotTo(HaveOccurred())
+				certPEM, keyPEM, err := serverCert.CertificatePEMAndPrivateKey()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(os.WriteFile(filepath.Join(dir, "admin.crt"), certPEM, 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(dir, "admin.key"), keyPEM, 0600)).To(Succeed())
+
+				cfg.Admin = admin.Config{
+					ListenAddr: fmt.Sprintf("localhost:%d", adminPort),
+					CertFile:   filepath.Join(dir, "admin.crt"),
+					KeyFile:    filepath.Join(dir, "admin.key"),
+					CAFile:     filepath.Join(dir, "ca.crt"),
+				}
+
+				clientCert, err := ca.BuildSignedCertificate("operator")
+				Expect(err).NotTo(HaveOccurred())
+				clientTLSCert, err := clientCert.TLSCertificate()
+				Expect(err).NotTo(HaveOccurred())
+				caCertPool, err := ca.CertPool()
+				Expect(err).NotTo(HaveOccurred())
+				clientTLSConfig, err := tlsconfig.Build(
+					tlsconfig.WithInternalServiceDefaults(),
+					tlsconfig.WithIdentity(clientTLSCert),
+				).Client(tlsconfig.WithAuthority(caCertPool))
+				Expect(err).NotTo(HaveOccurred())
+				adminClient = &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+			})
+
+			It("lists and purges the digests of served files", func() {
+				resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+
+				resp, err = adminClient.Get(fmt.Sprintf("https://localhost:%d/v1/digests", adminPort))
+				Expect(err).NotTo(HaveOccurred())
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(string(body)).To(ContainSubstring(`"path":"/test"`))
+
+				request, err := http.NewRequest("DELETE", fmt.Sprintf("https://localhost:%d/v1/digests?path=/test", adminPort), nil)
+				Expect(err).NotTo(HaveOccurred())
+				resp, err = adminClient.Do(request)
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Eventually(session.Out).Should(gbytes.Say("file-server.admin.purged-digests"))
+			})
+
+			Context("when asset stats are enabled", func() {
+				BeforeEach(func() {
+					cfg.AssetStats = assetstats.Config{Enabled: true}
+				})
+
+				It("reports the most downloaded assets", func() {
+					for i := 0; i < 2; i++ {
+						resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+						Expect(err).NotTo(HaveOccurred())
+						resp.Body.Close()
+					}
+
+					resp, err := adminClient.Get(fmt.Sprintf("https://localhost:%d/v1/assets?top=1", adminPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+					var assets []assetstats.Asset
+					Expect(json.NewDecoder(resp.Body).Decode(&assets)).To(Succeed())
+					Expect(assets).To(ConsistOf(HaveField("Requests", BeNumerically("==", 2))))
+					Expect(assets[0].Path).To(Equal("/test"))
+					Expect(assets[0].UniqueClients).To(BeNumerically("==", 1))
+				})
+			})
+
+			It("rejects clients without a certificate", func() {
+				transport := adminClient.Transport.(*http.Transport).Clone()
+				transport.TLSClientConfig.Certificates = nil
+				_, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://localhost:%d/v1/digests", adminPort))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when prometheus is served on the debug server", func() {
+			var debugPort int
+
+			BeforeEach(func() {
+				debugPort = 8482 + GinkgoParallelProcess()
+				cfg.Prometheus = instrument.Config{Enabled: true}
+				flags = []string{"-debugAddr", fmt.Sprintf("127.0.0.1:%d", debugPort)}
+			})
+
+			It("serves /metrics next to the debug routes", func() {
+				resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/pprof/", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when the debug server is started without prometheus", func() {
+			var debugPort int
+
+			BeforeEach(func() {
+				debugPort = 8482 + GinkgoParallelProcess()
+				flags = []string{"-debugAddr", fmt.Sprintf("127.0.0.1:%d", debugPort)}
+			})
+
+			It("serves only the debug routes", func() {
+				resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/pprof/", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when prometheus is served on the debug address from the config", func() {
+			var debugPort int
+
+			BeforeEach(func() {
+				debugPort = 8482 + GinkgoParallelProcess()
+				cfg.Prometheus = instrument.Config{Enabled: true}
+				cfg.DebugAddress = fmt.Sprintf("127.0.0.1:%d", debugPort)
+			})
+
+			It("serves /metrics without the debug routes", func() {
+				resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/metrics", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				resp, err = http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/pprof/", debugPort))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			})
+		})
+
+		Context("when only the debug address is configured", func() {
+			var debugPort int
+
+			BeforeEach(func() {
+				debugPort = 8482 + GinkgoParallelProcess()
+				cfg.DebugAddress = fmt.Sprintf("127.0.0.1:%d", debugPort)
+			})
+
+			It("does not start the debug server", func() {
+				_, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/debug/pprof/", debugPort))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when the listener is inherited through LISTEN_FDS", func() {
+			var inheritedAddr string
+
+			BeforeEach(func() {
+				listener, err := net.Listen("tcp", "127.0.0.1:0")
+				Expect(err).NotTo(HaveOccurred())
+				inheritedAddr = listener.Addr().String()
+
+				file, err := listener.(*net.TCPListener).File()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(listener.Close()).To(Succeed())
+				DeferCleanup(file.Close)
+
+				inheritedFiles = []*os.File{file}
+				inheritedEnv = []string{"LISTEN_FDS=1", "LISTEN_FDNAMES=http"}
+				cfg.ServerAddress = "fd://http"
+			})
+
+			It("serves on it across restarts", func() {
+				resp, err := http.Get(fmt.Sprintf("http://%s/v1/static/test", inheritedAddr))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				session.Interrupt().Wait()
+				session = start()
+
+				resp, err = http.Get(fmt.Sprintf("http://%s/v1/static/test", inheritedAddr))
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("when signed URLs are configured", func() {
+		BeforeEach(func() {
+			servedDirectory, err = os.MkdirTemp("", "file_server-test")
+			Expect(err).NotTo(HaveOccurred())
+
+			port = 8182 + GinkgoParallelProcess()
+			cfg = config.FileServerConfig{
+				LagerConfig: lagerflags.LagerConfig{
+					LogLevel:   lagerflags.INFO,
+					TimeFormat: lagerflags.FormatUnixEpoch,
+				},
+				StaticDirectory: servedDirectory,
+				ServerAddress:   fmt.Sprintf("localhost:%d", port),
+				AuthorizationRules: authorization.Rules{
+					{PathPrefix: "/v1/static/", Subjects: []string{"nobody"}},
+				},
+				SignedURLKeys: signedurl.Keys{
+					{ID: "key-1", Secret: "a-secret-that-is-long-enough-to-use"},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			configFile, err := os.CreateTemp("", "file_server-test-config")
+			Expect(err).NotTo(HaveOccurred())
+			configPath = configFile.Name()
+
+			encoder := json.NewEncoder(configFile)
+			err = encoder.Encode(&cfg)
+			Expect(err).NotTo(HaveOccurred())
+
+			session = start()
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+		})
+
+		It("serves the file for a URL minted by the sign-url command", func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			signSession, err := gexec.Start(exec.Command(fileServerBinary,
+				"sign-url", "-config", configPath, "-expires-in", "1m", "-base-url", fmt.Sprintf("http://localhost:%d", port), "test",
+			), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(signSession).Should(gexec.Exit(0))
+			signedURL := strings.TrimSpace(string(signSession.Out.Contents()))
+			Expect(signedURL).To(HavePrefix(fmt.Sprintf("http://localhost:%d/v1/static/test?", port)))
+
+			resp, err = http.Get(signedURL)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("hello"))
+		})
+
+		It("logs requests the authorization rules reject", func() {
+			resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusForbidden))
+
+			Eventually(session.Out).Should(gbytes.Say(`file-server.static-file.response.*"status":403`))
+		})
+
+		It("fails to sign with an unknown key id", func() {
+			signSession, err := gexec.Start(exec.Command(fileServerBinary,
+				"sign-url", "-config", configPath, "-key-id", "unknown", "test",
+			), GinkgoWriter, GinkgoWriter)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(signSession).Should(gexec.Exit(1))
+			Expect(signSession.Err).To(gbytes.Say(`no signing key with id "unknown"`))
+		})
 	})
 
 	Context("when HTTPS server is enabled", func() {
@@ -154,12 +648,13 @@ var _ = Describe("File server", func() {
 
 		Context("when all required HTTPS configuration is provided", func() {
 			var (
+				ca                *certtest.Authority
 				caCertPool        *x509.CertPool
 				certFile, keyFile *os.File
 			)
 
 			BeforeEach(func() {
-				ca, err := certtest.BuildCA("test-ca")
+				ca, err = certtest.BuildCA("test-ca")
 				Expect(err).NotTo(HaveOccurred())
 				cert, err := ca.BuildSignedCertificate("fileserver")
 				Expect(err).NotTo(HaveOccurred())
@@ -221,6 +716,199 @@ var _ = Describe("File server", func() {
 				Expect(string(body)).To(Equal("hello"))
 			})
 
+			It("checks the certificate for readiness", func() {
+				clientTLSConfig, err := tlsconfig.Build(
+					tlsconfig.WithInternalServiceDefaults(),
+				).Client(tlsconfig.WithAuthority(caCertPool))
+				Expect(err).NotTo(HaveOccurred())
+
+				httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+				resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/readyz", tlsPort))
+				Expect(err).NotTo(HaveOccurred())
+				var report health.Report
+				Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+				resp.Body.Close()
+				Expect(report.Checks).To(HaveKeyWithValue("tls-certificate", health.CheckResult{Status: health.StatusOK}))
+			})
+
+			It("negotiates HTTP/2", func() {
+				clientTLSConfig, err := tlsconfig.Build(
+					tlsconfig.WithInternalServiceDefaults(),
+				).Client(tlsconfig.WithAuthority(caCertPool))
+				Expect(err).NotTo(HaveOccurred())
+
+				httpClient := &http.Client{
+					Transport: &http.Transport{
+						TLSClientConfig:   clientTLSConfig,
+						ForceAttemptHTTP2: true,
+					},
+				}
+				resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Proto).To(Equal("HTTP/2.0"))
+				Expect(resp.TLS.NegotiatedProtocol).To(Equal("h2"))
+			})
+
+			Context("when HSTS is configured", func() {
+				BeforeEach(func() {
+					cfg.HSTS = hsts.Config{MaxAgeSeconds: 600, IncludeSubDomains: true}
+				})
+
+				It("sends the Strict-Transport-Security header", func() {
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+					resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.Header.Get("Strict-Transport-Security")).To(Equal("max-age=600; includeSubDomains"))
+				})
+
+				It("does not send it on redirects over plain HTTP", func() {
+					httpClient := &http.Client{
+						CheckRedirect: func(req *http.Request, via []*http.Request) error {
+							return http.ErrUseLastResponse
+						},
+					}
+					resp, err := httpClient.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.StatusCode).To(Equal(http.StatusMovedPermanently))
+					Expect(resp.Header).NotTo(HaveKey("Strict-Transport-Security"))
+				})
+			})
+
+			Context("when plain HTTP is served too", func() {
+				BeforeEach(func() {
+					cfg.PlainHTTP = redirect.Config{Policy: redirect.PolicyServe}
+				})
+
+				It("serves the file over plain HTTP without the HSTS header", func() {
+					resp, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Header).NotTo(HaveKey("Strict-Transport-Security"))
+				})
+			})
+
+			Context("when plain HTTP is disabled", func() {
+				BeforeEach(func() {
+					cfg.PlainHTTP = redirect.Config{Policy: redirect.PolicyDisabled}
+				})
+
+				It("does not listen on the plain HTTP address", func() {
+					_, err := http.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).To(HaveOccurred())
+				})
+			})
+
+			Context("when PROXY protocol headers are trusted", func() {
+				BeforeEach(func() {
+					cfg.ProxyProtocolTrustedCIDRs = []string{"127.0.0.1/32", "::1/128"}
+				})
+
+				It("accepts them on the plain HTTP listener that redirects", func() {
+					conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+					Expect(err).NotTo(HaveOccurred())
+					defer conn.Close()
+
+					_, err = fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 4242 80\r\n"+
+						"GET /v1/static/test HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+					Expect(err).NotTo(HaveOccurred())
+
+					resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+					Expect(resp.StatusCode).To(Equal(http.StatusMovedPermanently))
+				})
+			})
+
+			Context("when redirects use 308 and a fixed host", func() {
+				BeforeEach(func() {
+					cfg.PlainHTTP = redirect.Config{Status: http.StatusPermanentRedirect, Host: "files.example.com"}
+				})
+
+				It("redirects to the configured host", func() {
+					httpClient := &http.Client{
+						CheckRedirect: func(req *http.Request, via []*http.Request) error {
+							return http.ErrUseLastResponse
+						},
+					}
+					resp, err := httpClient.Get(fmt.Sprintf("http://localhost:%d/v1/static/test", port))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.StatusCode).To(Equal(http.StatusPermanentRedirect))
+					Expect(resp.Header.Get("Location")).To(Equal(fmt.Sprintf("https://files.example.com:%d/v1/static/test", tlsPort)))
+				})
+			})
+
+			Context("when http3 is enabled", func() {
+				BeforeEach(func() {
+					cfg.HTTP3ListenAddr = fmt.Sprintf("localhost:%d", tlsPort)
+				})
+
+				It("advertises HTTP/3 on HTTPS responses and serves the file over it", func() {
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}}
+					resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					resp.Body.Close()
+					Expect(resp.Header.Get("Alt-Svc")).To(ContainSubstring(fmt.Sprintf(`h3=":%d"`, tlsPort)))
+
+					transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: caCertPool}}
+					defer transport.Close()
+					resp, err = (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+					Expect(resp.Proto).To(Equal("HTTP/3.0"))
+					body, err := io.ReadAll(resp.Body)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(body)).To(Equal("hello"))
+				})
+			})
+
+			Context("when the configured alpn protocols leave out h2", func() {
+				BeforeEach(func() {
+					cfg.ALPN = []string{"http/1.1"}
+				})
+
+				It("negotiates HTTP/1.1", func() {
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig:   clientTLSConfig,
+							ForceAttemptHTTP2: true,
+						},
+					}
+					resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.Proto).To(Equal("HTTP/1.1"))
+				})
+			})
+
 			It("fails to return test when caCertPool is missing", func() {
 				clientTLSConfig, err := tlsconfig.Build(
 					tlsconfig.WithInternalServiceDefaults(),
@@ -262,6 +950,136 @@ var _ = Describe("File server", func() {
 				Expect(err).NotTo(HaveOccurred())
 				Expect(location.String()).To(Equal(fmt.Sprintf("https://file-server.service.test.com:%d/v1/static/test", tlsPort)))
 			})
+
+			Context("when client certificates are required", func() {
+				var caFile *os.File
+
+				BeforeEach(func() {
+					caPEM, err := ca.CertificatePEM()
+					Expect(err).NotTo(HaveOccurred())
+
+					caFile, err = os.CreateTemp("", "testca")
+					Expect(err).NotTo(HaveOccurred())
+					_, err = caFile.Write(caPEM)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(caFile.Close()).To(Succeed())
+
+					cfg.CAFile = caFile.Name()
+					cfg.RequireClientCert = true
+				})
+
+				AfterEach(func() {
+					Expect(os.Remove(caFile.Name())).To(Succeed())
+				})
+
+				It("returns the test file to clients presenting a certificate signed by the CA", func() {
+					clientCert, err := ca.BuildSignedCertificate("client")
+					Expect(err).NotTo(HaveOccurred())
+					clientTLSCert, err := clientCert.TLSCertificate()
+					Expect(err).NotTo(HaveOccurred())
+
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+						tlsconfig.WithIdentity(clientTLSCert),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig: clientTLSConfig,
+						},
+					}
+					resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).NotTo(HaveOccurred())
+					defer resp.Body.Close()
+
+					Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				})
+
+				Context("when authorization rules are configured", func() {
+					BeforeEach(func() {
+						cfg.AuthorizationRules = authorization.Rules{
+							{PathPrefix: "/v1/static/segment-a/", Subjects: []string{"cell-a"}},
+						}
+					})
+
+					It("only serves the restricted assets to matching clients", func() {
+						get := func(commonName, path string) int {
+							clientCert, err := ca.BuildSignedCertificate(commonName)
+							Expect(err).NotTo(HaveOccurred())
+							clientTLSCert, err := clientCert.TLSCertificate()
+							Expect(err).NotTo(HaveOccurred())
+
+							clientTLSConfig, err := tlsconfig.Build(
+								tlsconfig.WithInternalServiceDefaults(),
+								tlsconfig.WithIdentity(clientTLSCert),
+							).Client(tlsconfig.WithAuthority(caCertPool))
+							Expect(err).NotTo(HaveOccurred())
+
+							httpClient := &http.Client{
+								Transport: &http.Transport{
+									TLSClientConfig: clientTLSConfig,
+								},
+							}
+							resp, err := httpClient.Get(fmt.Sprintf("https://localhost:%d%s", tlsPort, path))
+							Expect(err).NotTo(HaveOccurred())
+							defer resp.Body.Close()
+							return resp.StatusCode
+						}
+
+						Expect(os.Mkdir(filepath.Join(servedDirectory, "segment-a"), os.ModePerm)).To(Succeed())
+						Expect(os.WriteFile(filepath.Join(servedDirectory, "segment-a", "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+
+						Expect(get("cell-a", "/v1/static/segment-a/test")).To(Equal(http.StatusOK))
+						Expect(get("cell-b", "/v1/static/segment-a/test")).To(Equal(http.StatusForbidden))
+						Expect(get("cell-b", "/v1/static/test")).To(Equal(http.StatusOK))
+						Eventually(session.Out).Should(gbytes.Say("authorization.request-denied"))
+					})
+				})
+
+				It("rejects clients without a certificate and logs the handshake failure", func() {
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig: clientTLSConfig,
+						},
+					}
+					_, err = httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).To(HaveOccurred())
+
+					Eventually(session.Out).Should(gbytes.Say("tls-handshake-failed"))
+					Expect(session.Out).To(gbytes.Say(`"remote-addr":"127.0.0.1:\d+"`))
+				})
+
+				It("rejects clients presenting a certificate from another CA", func() {
+					otherCA, err := certtest.BuildCA("other-ca")
+					Expect(err).NotTo(HaveOccurred())
+					clientCert, err := otherCA.BuildSignedCertificate("client")
+					Expect(err).NotTo(HaveOccurred())
+					clientTLSCert, err := clientCert.TLSCertificate()
+					Expect(err).NotTo(HaveOccurred())
+
+					clientTLSConfig, err := tlsconfig.Build(
+						tlsconfig.WithInternalServiceDefaults(),
+						tlsconfig.WithIdentity(clientTLSCert),
+					).Client(tlsconfig.WithAuthority(caCertPool))
+					Expect(err).NotTo(HaveOccurred())
+
+					httpClient := &http.Client{
+						Transport: &http.Transport{
+							TLSClientConfig: clientTLSConfig,
+						},
+					}
+					_, err = httpClient.Get(fmt.Sprintf("https://localhost:%d/v1/static/test", tlsPort))
+					Expect(err).To(HaveOccurred())
+
+					Eventually(session.Out).Should(gbytes.Say("tls-handshake-failed"))
+				})
+			})
 		})
 	})
 })
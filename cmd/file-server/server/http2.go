@@ -0,0 +1,68 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Flow-control windows net/http accepts; other values are silently
+// replaced by its defaults.
+const (
+	minWindowSize = 64 << 10
+	maxWindowSize = 4<<20 - 1
+)
+
+// HTTP2Config tunes HTTP/2. It is always offered on TLS listeners through
+// ALPN, unless the configured ALPN protocols leave it out; plain listeners
+// only speak it with prior knowledge when H2C is set. Zero values keep the
+// net/http defaults. How long idle connections are kept open is set for
+// every protocol with WithIdleTimeout.
+type HTTP2Config struct {
+	H2C                    bool `json:"h2c,omitempty"`
+	MaxConcurrentStreams   int  `json:"max_concurrent_streams,omitempty"`
+	ConnectionWindowSize   int  `json:"connection_window_size,omitempty"`
+	StreamWindowSize       int  `json:"stream_window_size,omitempty"`
+	ReadIdleTimeoutSeconds int  `json:"read_idle_timeout_seconds,omitempty"`
+}
+
+// Validate checks that limits are not negative and that windows are within
+// the range net/http supports.
+func (c HTTP2Config) Validate() error {
+	if c.MaxConcurrentStreams < 0 || c.ReadIdleTimeoutSeconds < 0 {
+		return errors.New("http2 limits and timeouts must not be negative")
+	}
+	for name, size := range map[string]int{
+		"connection_window_size": c.ConnectionWindowSize,
+		"stream_window_size":     c.StreamWindowSize,
+	} {
+		if size != 0 && (size < minWindowSize || size > maxWindowSize) {
+			return fmt.Errorf("http2 %s must be between %d and %d", name, minWindowSize, maxWindowSize)
+		}
+	}
+	return nil
+}
+
+// WithHTTP2 applies config to the server.
+func WithHTTP2(config HTTP2Config) Option {
+	return func(s *httpServer) {
+		s.http2 = config
+	}
+}
+
+func (c HTTP2Config) apply(server *http.Server, tls bool) {
+	server.HTTP2 = &http.HTTP2Config{
+		MaxConcurrentStreams:          c.MaxConcurrentStreams,
+		MaxReceiveBufferPerConnection: c.ConnectionWindowSize,
+		MaxReceiveBufferPerStream:     c.StreamWindowSize,
+		SendPingTimeout:               time.Duration(c.ReadIdleTimeoutSeconds) * time.Second,
+	}
+
+	if !tls && c.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+		server.Protocols = protocols
+	}
+}
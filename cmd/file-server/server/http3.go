@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/tedsuo/ifrit"
+)
+
+// altSvcMaxAge is how long clients may remember the advertised HTTP/3
+// endpoint, in seconds.
+const altSvcMaxAge = 86400
+
+type http3Server struct {
+	logger    lager.Logger
+	address   string
+	handler   http.Handler
+	tlsConfig *tls.Config
+}
+
+// NewHTTP3 returns an ifrit.Runner serving HTTP/3 over QUIC on the UDP
+// address. QUIC always uses TLS 1.3, whatever versions tlsConfig allows.
+func NewHTTP3(logger lager.Logger, address string, handler http.Handler, tlsConfig *tls.Config) ifrit.Runner {
+	return &http3Server{
+		logger:    logger,
+		address:   address,
+		handler:   handler,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (s *http3Server) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := s.logger.Session("http3-server", lager.Data{"address": s.address})
+
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return err
+	}
+
+	tlsConfig := s.tlsConfig.Clone()
+	tlsConfig.MinVersion = tls.VersionTLS13
+	tlsConfig.MaxVersion = tls.VersionTLS13
+
+	server := &http3.Server{
+		Handler:   s.handler,
+		TLSConfig: http3.ConfigureTLSConfig(tlsConfig),
+	}
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		serverErrChan <- server.Serve(conn)
+	}()
+
+	close(ready)
+
+	select {
+	case err = <-serverErrChan:
+		conn.Close()
+		logger.Error("failed-to-serve", err)
+		return err
+
+	case <-signals:
+		err = server.Shutdown(context.Background())
+		conn.Close()
+		if err != nil {
+			logger.Error("failed-to-shutdown", err)
+		}
+		return err
+	}
+}
+
+// WithAltSvc advertises the HTTP/3 listener on http3Address to clients
+// through the Alt-Svc header of every response.
+func WithAltSvc(http3Address string) Option {
+	return func(s *httpServer) {
+		s.altSvcAddress = http3Address
+	}
+}
+
+func altSvcHandler(http3Address string, next http.Handler) (http.Handler, error) {
+	_, portString, err := net.SplitHostPort(http3Address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http3 port %q", portString)
+	}
+
+	altSvc := fmt.Sprintf(`%s=":%d"; ma=%d`, http3.NextProtoH3, port, altSvcMaxAge)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,89 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"code.cloudfoundry.org/tlsconfig"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/tedsuo/ifrit"
+	"github.com/tedsuo/ifrit/grouper"
+)
+
+var _ = Describe("HTTP/3", func() {
+	var (
+		address    string
+		caCertPool *x509.CertPool
+		process    ifrit.Process
+	)
+
+	BeforeEach(func() {
+		address = fmt.Sprintf("127.0.0.1:%d", 9382+GinkgoParallelProcess())
+
+		ca, err := certtest.BuildCA("test-ca")
+		Expect(err).NotTo(HaveOccurred())
+		caCertPool, err = ca.CertPool()
+		Expect(err).NotTo(HaveOccurred())
+		cert, err := ca.BuildSignedCertificate("server", certtest.WithIPs(net.ParseIP("127.0.0.1")))
+		Expect(err).NotTo(HaveOccurred())
+		tlsCert, err := cert.TLSCertificate()
+		Expect(err).NotTo(HaveOccurred())
+
+		// NewHTTP3 has to lift the TLS 1.2 limit of the defaults
+		serverTLSConfig, err := tlsconfig.Build(
+			tlsconfig.WithInternalServiceDefaults(),
+			tlsconfig.WithIdentity(tlsCert),
+		).Server()
+		Expect(err).NotTo(HaveOccurred())
+
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		})
+		logger := lagertest.NewTestLogger("test")
+
+		// TCP and UDP listen on the same port, as they would in production
+		process = ifrit.Invoke(grouper.NewParallel(os.Interrupt, grouper.Members{
+			{Name: "tls-server", Runner: server.NewTLS(logger, address, handler, serverTLSConfig, server.WithAltSvc(address))},
+			{Name: "http3-server", Runner: server.NewHTTP3(logger, address, handler, serverTLSConfig)},
+		}))
+	})
+
+	AfterEach(func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive())
+	})
+
+	It("serves requests over QUIC", func() {
+		transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: caCertPool}}
+		defer transport.Close()
+
+		resp, err := (&http.Client{Transport: transport}).Get(fmt.Sprintf("https://%s/", address))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		Expect(resp.Proto).To(Equal("HTTP/3.0"))
+		body, err := io.ReadAll(resp.Body)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(body)).To(Equal("HTTP/3.0"))
+	})
+
+	It("advertises the HTTP/3 listener on TCP responses", func() {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: caCertPool}}}
+
+		resp, err := client.Get(fmt.Sprintf("https://%s/", address))
+		Expect(err).NotTo(HaveOccurred())
+		defer resp.Body.Close()
+
+		Expect(resp.Header.Get("Alt-Svc")).To(Equal(fmt.Sprintf(`h3=":%d"; ma=86400`, 9382+GinkgoParallelProcess())))
+	})
+})
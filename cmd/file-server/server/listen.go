@@ -0,0 +1,185 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	unixScheme = "unix://"
+	fdScheme   = "fd://"
+
+	// listenFDsStart is the first file descriptor passed by systemd style
+	// socket activation.
+	listenFDsStart = 3
+)
+
+// ValidateAddress checks that address is a TCP host:port, a unix:// URL
+// with an absolute path or an fd:// URL naming an inherited listener.
+func ValidateAddress(address string) error {
+	switch {
+	case strings.HasPrefix(address, unixScheme):
+		if !filepath.IsAbs(strings.TrimPrefix(address, unixScheme)) {
+			return fmt.Errorf("unix socket path in %q must be absolute", address)
+		}
+	case strings.HasPrefix(address, fdScheme):
+		if strings.TrimPrefix(address, fdScheme) == "" {
+			return fmt.Errorf("%q does not name an inherited listener", address)
+		}
+	default:
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return fmt.Errorf("invalid listen address %q: %w", address, err)
+		}
+	}
+	return nil
+}
+
+// WithUnixSocketMode sets the permissions of unix sockets the server creates.
+func WithUnixSocketMode(mode os.FileMode) Option {
+	return func(s *httpServer) {
+		s.unixSocketMode = mode
+	}
+}
+
+func listen(address string, unixSocketMode os.FileMode) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(address, unixScheme):
+		return listenUnix(strings.TrimPrefix(address, unixScheme), unixSocketMode)
+	case strings.HasPrefix(address, fdScheme):
+		return inheritedListener(strings.TrimPrefix(address, fdScheme))
+	default:
+		return net.Listen("tcp", address)
+	}
+}
+
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	// a socket left behind by a previous run would make the listen fail
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+var (
+	inheritOnce sync.Once
+	inherited   map[string]net.Listener
+	inheritErr  error
+)
+
+// inheritedListener returns the listener passed through LISTEN_FDS with the
+// given name in LISTEN_FDNAMES, or at the given zero based index. Each
+// listener can only be taken once.
+func inheritedListener(name string) (net.Listener, error) {
+	inheritOnce.Do(func() {
+		inherited, inheritErr = listenersFromEnv()
+	})
+	if inheritErr != nil {
+		return nil, inheritErr
+	}
+
+	listener, ok := inherited[name]
+	if !ok {
+		return nil, fmt.Errorf("no inherited listener named %q", name)
+	}
+	for key, l := range inherited {
+		if l == listener {
+			delete(inherited, key)
+		}
+	}
+	return listener, nil
+}
+
+func listenersFromEnv() (map[string]net.Listener, error) {
+	defer func() {
+		// keep child processes from picking up the listeners
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, errors.New("LISTEN_PID does not match this process")
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, errors.New("no listeners were passed through LISTEN_FDS")
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	listeners := map[string]net.Listener{}
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		syscall.CloseOnExec(fd)
+
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("listen-fd-%d", i))
+		listener, err := net.FileListener(file)
+		// FileListener works on a copy of the descriptor
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inherited file descriptor %d is not a listener: %w", fd, err)
+		}
+
+		listeners[strconv.Itoa(i)] = listener
+		if i < len(names) && names[i] != "" {
+			listeners[names[i]] = listener
+		}
+	}
+
+	return listeners, nil
+}
+
+// UnixSocketMode is the octal permission mode, such as "0660", given to unix
+// sockets the server creates. Empty keeps the mode derived from the umask.
+type UnixSocketMode string
+
+// Validate checks that the mode is an octal permission mode.
+func (m UnixSocketMode) Validate() error {
+	_, err := m.FileMode()
+	return err
+}
+
+// FileMode parses the mode.
+func (m UnixSocketMode) FileMode() (os.FileMode, error) {
+	if m == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(string(m), 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid unix_socket_mode %q, use an octal permission mode such as 0660", string(m))
+	}
+	return os.FileMode(mode), nil
+}
@@ -0,0 +1,171 @@
+package server_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Listen addresses", func() {
+	DescribeTable("ValidateAddress",
+		func(address string, valid bool) {
+			if valid {
+				Expect(server.ValidateAddress(address)).To(Succeed())
+			} else {
+				Expect(server.ValidateAddress(address)).NotTo(Succeed())
+			}
+		},
+		Entry("a host and port", "127.0.0.1:8080", true),
+		Entry("a port only", ":8080", true),
+		Entry("a missing port", "127.0.0.1", false),
+		Entry("a unix socket", "unix:///var/vcap/data/fileserver/fileserver.sock", true),
+		Entry("a relative unix socket", "unix://fileserver.sock", false),
+		Entry("a named inherited listener", "fd://http", true),
+		Entry("an inherited listener without a name", "fd://", false),
+	)
+
+	DescribeTable("UnixSocketMode",
+		func(mode server.UnixSocketMode, expected os.FileMode, valid bool) {
+			fileMode, err := mode.FileMode()
+			if valid {
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fileMode).To(Equal(expected))
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("empty", server.UnixSocketMode(""), os.FileMode(0), true),
+		Entry("group writable", server.UnixSocketMode("0660"), os.FileMode(0660), true),
+		Entry("without a leading zero", server.UnixSocketMode("600"), os.FileMode(0600), true),
+		Entry("not octal", server.UnixSocketMode("0990"), os.FileMode(0), false),
+		Entry("with extra bits", server.UnixSocketMode("4755"), os.FileMode(0), false),
+	)
+
+	Context("when listening on a unix socket", func() {
+		var (
+			socketPath string
+			opts       []server.Option
+			process    ifrit.Process
+			client     *http.Client
+			localAddrs chan net.Addr
+		)
+
+		BeforeEach(func() {
+			socketPath = filepath.Join(GinkgoT().TempDir(), "fileserver.sock")
+			opts = nil
+			localAddrs = make(chan net.Addr, 1)
+			client = &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+					},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case localAddrs <- r.Context().Value(http.LocalAddrContextKey).(net.Addr):
+				default:
+				}
+				w.Write([]byte("hello"))
+			})
+			process = ifrit.Invoke(server.New(lagertest.NewTestLogger("test"), "unix://"+socketPath, handler, opts...))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("serves the handler", func() {
+			resp, err := client.Get("http://fileserver/")
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("hello"))
+		})
+
+		It("lets handlers tell unix socket clients apart from remote ones", func() {
+			resp, err := client.Get("http://fileserver/")
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+
+			Expect(<-localAddrs).To(BeAssignableToTypeOf(&net.UnixAddr{}))
+		})
+
+		It("removes the socket when signalled", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(socketPath).NotTo(BeAnExistingFile())
+		})
+
+		Context("when a socket mode is configured", func() {
+			BeforeEach(func() {
+				opts = append(opts, server.WithUnixSocketMode(0600))
+			})
+
+			It("applies it to the socket", func() {
+				info, err := os.Stat(socketPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Type()).To(Equal(fs.ModeSocket))
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			})
+		})
+
+		Context("when PROXY protocol is enabled", func() {
+			BeforeEach(func() {
+				opts = append(opts, server.WithProxyProtocol([]string{"127.0.0.1/32"}))
+			})
+
+			It("serves the handler", func() {
+				resp, err := client.Get("http://fileserver/")
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("hello"))
+			})
+		})
+
+		Context("when a stale socket is left behind", func() {
+			BeforeEach(func() {
+				listener, err := net.Listen("unix", socketPath)
+				Expect(err).NotTo(HaveOccurred())
+				listener.(*net.UnixListener).SetUnlinkOnClose(false)
+				Expect(listener.Close()).To(Succeed())
+				Expect(socketPath).To(BeAnExistingFile())
+			})
+
+			It("replaces it", func() {
+				resp, err := client.Get("http://fileserver/")
+				Expect(err).NotTo(HaveOccurred())
+				resp.Body.Close()
+			})
+		})
+	})
+
+	Context("when the socket path is taken by another file", func() {
+		It("fails to start", func() {
+			socketPath := filepath.Join(GinkgoT().TempDir(), "fileserver.sock")
+			Expect(os.WriteFile(socketPath, nil, 0644)).To(Succeed())
+
+			runner := server.New(lagertest.NewTestLogger("test"), "unix://"+socketPath, http.NotFoundHandler())
+			Expect(runner.Run(nil, make(chan struct{}))).To(MatchError(ContainSubstring("is not a socket")))
+		})
+	})
+})
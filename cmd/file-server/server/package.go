@@ -0,0 +1 @@
+package server // import "code.cloudfoundry.org/fileserver/cmd/file-server/server"
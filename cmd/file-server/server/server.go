@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/pires/go-proxyproto"
+	"github.com/tedsuo/ifrit"
+)
+
+type httpServer struct {
+	logger    lager.Logger
+	address   string
+	handler   http.Handler
+	tlsConfig *tls.Config
+
+	proxyProtocolTrustedCIDRs []string
+	http2                     HTTP2Config
+	altSvcAddress             string
+	unixSocketMode            os.FileMode
+	idleTimeout               time.Duration
+	connState                 func(net.Conn, http.ConnState)
+	auditRecorder             audit.Recorder
+}
+
+// Option configures optional behaviour of the server.
+type Option func(*httpServer)
+
+// WithProxyProtocol accepts PROXY protocol v1 and v2 headers from peers in
+// the trusted CIDRs and exposes the client address they carry as the
+// request's RemoteAddr. Connections from other peers that send a header are
+// rejected. It has no effect on unix socket listeners.
+func WithProxyProtocol(trustedCIDRs []string) Option {
+	return func(s *httpServer) {
+		s.proxyProtocolTrustedCIDRs = trustedCIDRs
+	}
+}
+
+// WithIdleTimeout closes keep-alive connections, HTTP/1.1 and HTTP/2 alike,
+// that have been idle for timeout. Zero keeps the net/http default.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *httpServer) {
+		s.idleTimeout = timeout
+	}
+}
+
+// WithConnState is called whenever a client connection changes state, see
+// http.Server.ConnState.
+func WithConnState(connState func(net.Conn, http.ConnState)) Option {
+	return func(s *httpServer) {
+		s.connState = connState
+	}
+}
+
+// WithAuditRecorder records failed TLS handshakes in recorder.
+func WithAuditRecorder(recorder audit.Recorder) Option {
+	return func(s *httpServer) {
+		s.auditRecorder = recorder
+	}
+}
+
+// New returns an ifrit.Runner serving plain HTTP on address, which may be a
+// TCP host:port, a unix:// socket path or an fd:// inherited listener.
+func New(logger lager.Logger, address string, handler http.Handler, opts ...Option) ifrit.Runner {
+	return newServer(logger, address, handler, nil, opts)
+}
+
+// NewTLS returns an ifrit.Runner serving HTTPS on address. Failed TLS
+// handshakes are logged together with the address of the peer, the server
+// name it asked for and the subject of the certificate it presented.
+func NewTLS(logger lager.Logger, address string, handler http.Handler, tlsConfig *tls.Config, opts ...Option) ifrit.Runner {
+	return newServer(logger, address, handler, tlsConfig, opts)
+}
+
+func newServer(logger lager.Logger, address string, handler http.Handler, tlsConfig *tls.Config, opts []Option) *httpServer {
+	s := &httpServer{
+		logger:    logger,
+		address:   address,
+		handler:   handler,
+		tlsConfig: tlsConfig,
+
+		auditRecorder: audit.Discard,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *httpServer) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	logger := s.logger.Session("http-server", lager.Data{"address": s.address})
+
+	handler := s.handler
+	if s.altSvcAddress != "" {
+		var err error
+		handler, err = altSvcHandler(s.altSvcAddress, handler)
+		if err != nil {
+			return err
+		}
+	}
+
+	listener, err := listen(s.address, s.unixSocketMode)
+	if err != nil {
+		return err
+	}
+	// unix socket peers have no address the trusted CIDRs could match
+	if len(s.proxyProtocolTrustedCIDRs) > 0 && listener.Addr().Network() == "tcp" {
+		policy, err := proxyproto.PolicyFromRanges(s.proxyProtocolTrustedCIDRs, proxyproto.USE, proxyproto.REJECT)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		// the PROXY header precedes the TLS handshake
+		listener = &proxyproto.Listener{Listener: listener, ConnPolicy: policy}
+	}
+	if s.tlsConfig != nil {
+		tlsConfig := s.tlsConfig
+		if len(tlsConfig.NextProtos) == 0 {
+			// http.Server only advertises h2 itself when it creates the
+			// TLS listener
+			tlsConfig = tlsConfig.Clone()
+			tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+		}
+		listener = newHandshakeListener(listener, tlsConfig, logger, s.auditRecorder)
+	}
+
+	server := &http.Server{
+		Handler:     handler,
+		ErrorLog:    log.New(&errorLogWriter{logger: logger}, "", 0),
+		ConnState:   s.connState,
+		IdleTimeout: s.idleTimeout,
+	}
+	s.http2.apply(server, s.tlsConfig != nil)
+
+	serverErrChan := make(chan error, 1)
+	go func() {
+		serverErrChan <- server.Serve(listener)
+	}()
+
+	close(ready)
+
+	select {
+	case err = <-serverErrChan:
+		return err
+
+	case <-signals:
+		return server.Shutdown(context.Background())
+	}
+}
+
+// errorLogWriter forwards the messages net/http writes to its ErrorLog to
+// lager.
+type errorLogWriter struct {
+	logger lager.Logger
+}
+
+func (w *errorLogWriter) Write(p []byte) (int, error) {
+	w.logger.Error("http-server-error", errors.New(strings.TrimSpace(string(p))))
+	return len(p), nil
+}
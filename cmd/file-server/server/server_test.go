@@ -0,0 +1,342 @@
+package server_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"code.cloudfoundry.org/tlsconfig"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/pires/go-proxyproto"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		logger  *lagertest.TestLogger
+		address string
+		handler http.Handler
+		process ifrit.Process
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		address = fmt.Sprintf("127.0.0.1:%d", 9182+GinkgoParallelProcess())
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		})
+	})
+
+	AfterEach(func() {
+		if process != nil {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		}
+	})
+
+	Context("when serving plain HTTP", func() {
+		BeforeEach(func() {
+			process = ifrit.Invoke(server.New(logger, address, handler))
+		})
+
+		It("serves the handler", func() {
+			resp, err := http.Get(fmt.Sprintf("http://%s/", address))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(body)).To(Equal("hello"))
+		})
+
+		It("exits cleanly when signalled", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			process = nil
+		})
+	})
+
+	Context("when an idle timeout is set", func() {
+		BeforeEach(func() {
+			process = ifrit.Invoke(server.New(logger, address, handler, server.WithIdleTimeout(100*time.Millisecond)))
+		})
+
+		It("closes idle HTTP/1.1 keep-alive connections", func() {
+			conn, err := net.Dial("tcp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+			Expect(err).NotTo(HaveOccurred())
+			reader := bufio.NewReader(conn)
+			resp, err := http.ReadResponse(reader, nil)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			_, err = reader.ReadByte()
+			Expect(err).To(MatchError(io.EOF))
+		})
+	})
+
+	Context("when PROXY protocol is enabled", func() {
+		var trustedCIDRs []string
+
+		BeforeEach(func() {
+			trustedCIDRs = []string{"127.0.0.1/32"}
+			handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(r.RemoteAddr))
+			})
+		})
+
+		JustBeforeEach(func() {
+			process = ifrit.Invoke(server.New(logger, address, handler, server.WithProxyProtocol(trustedCIDRs)))
+		})
+
+		sendRequest := func(header string) (int, string) {
+			conn, err := net.Dial("tcp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer conn.Close()
+
+			_, err = io.WriteString(conn, header+"GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			Expect(err).NotTo(HaveOccurred())
+			return resp.StatusCode, string(body)
+		}
+
+		It("uses the client address from a v1 header sent by a trusted peer", func() {
+			status, body := sendRequest("PROXY TCP4 192.0.2.10 127.0.0.1 40000 8080\r\n")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("192.0.2.10:40000"))
+		})
+
+		It("uses the client address from a v2 header sent by a trusted peer", func() {
+			header := proxyproto.HeaderProxyFromAddrs(2,
+				&net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 50000},
+				&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080},
+			)
+			raw, err := header.Format()
+			Expect(err).NotTo(HaveOccurred())
+
+			status, body := sendRequest(string(raw))
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal("198.51.100.7:50000"))
+		})
+
+		It("accepts connections without a header", func() {
+			status, body := sendRequest("")
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(MatchRegexp(`^127\.0\.0\.1:\d+$`))
+		})
+
+		Context("when the peer is not trusted", func() {
+			BeforeEach(func() {
+				trustedCIDRs = []string{"10.0.0.0/8"}
+			})
+
+			It("closes connections sending a header without serving them", func() {
+				conn, err := net.Dial("tcp", address)
+				Expect(err).NotTo(HaveOccurred())
+				defer conn.Close()
+
+				_, err = io.WriteString(conn, "PROXY TCP4 192.0.2.10 127.0.0.1 40000 8080\r\nGET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+				_, err = http.ReadResponse(bufio.NewReader(conn), nil)
+				Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+			})
+
+			It("accepts connections without a header", func() {
+				status, body := sendRequest("")
+				Expect(status).To(Equal(http.StatusOK))
+				Expect(body).To(MatchRegexp(`^127\.0\.0\.1:\d+$`))
+			})
+		})
+	})
+
+	Context("when serving HTTPS", func() {
+		var (
+			ca              *certtest.Authority
+			caCertPool      *x509.CertPool
+			clientTLSConfig *tls.Config
+			serverOpts      []server.Option
+		)
+
+		BeforeEach(func() {
+			var err error
+			ca, err = certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			caCertPool, err = ca.CertPool()
+			Expect(err).NotTo(HaveOccurred())
+
+			clientTLSConfig, err = tlsconfig.Build(
+				tlsconfig.WithInternalServiceDefaults(),
+			).Client(tlsconfig.WithAuthority(caCertPool))
+			Expect(err).NotTo(HaveOccurred())
+
+			serverOpts = nil
+		})
+
+		JustBeforeEach(func() {
+			cert, err := ca.BuildSignedCertificate("server", certtest.WithIPs(net.ParseIP("127.0.0.1")))
+			Expect(err).NotTo(HaveOccurred())
+			tlsCert, err := cert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+
+			serverTLSConfig, err := tlsconfig.Build(
+				tlsconfig.WithInternalServiceDefaults(),
+				tlsconfig.WithIdentity(tlsCert),
+			).Server(tlsconfig.WithClientAuthentication(caCertPool))
+			Expect(err).NotTo(HaveOccurred())
+
+			process = ifrit.Invoke(server.NewTLS(logger, address, handler, serverTLSConfig, serverOpts...))
+		})
+
+		It("logs rejected handshakes with the remote address", func() {
+			conn, err := tls.Dial("tcp", address, clientTLSConfig)
+			if err == nil {
+				// with TLS 1.3 the client only learns about the rejection on first read
+				_, err = conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+			Expect(err).To(HaveOccurred())
+
+			Eventually(logger).Should(gbytes.Say("test.http-server.tls-handshake-failed"))
+			Expect(logger.Logs()).To(ContainElement(HaveField("Data", HaveKeyWithValue("remote-addr", MatchRegexp(`^127\.0\.0\.1:\d+$`)))))
+		})
+
+		It("logs the server name and certificate subject the client presented", func() {
+			otherCA, err := certtest.BuildCA("other-ca")
+			Expect(err).NotTo(HaveOccurred())
+			clientCert, err := otherCA.BuildSignedCertificate("client")
+			Expect(err).NotTo(HaveOccurred())
+			clientTLSCert, err := clientCert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+			// sent even though the server does not accept its CA
+			clientTLSConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return &clientTLSCert, nil
+			}
+			clientTLSConfig.ServerName = "files.example.com"
+			clientTLSConfig.InsecureSkipVerify = true
+
+			conn, err := tls.Dial("tcp", address, clientTLSConfig)
+			if err == nil {
+				_, err = conn.Read(make([]byte, 1))
+				conn.Close()
+			}
+			Expect(err).To(HaveOccurred())
+
+			Eventually(logger).Should(gbytes.Say("test.http-server.tls-handshake-failed"))
+			Expect(logger.Logs()).To(ContainElement(HaveField("Data", And(
+				HaveKeyWithValue("server-name", "files.example.com"),
+				HaveKeyWithValue("client-certificate-subject", ContainSubstring("CN=client")),
+			))))
+		})
+
+		It("does not hold up other clients while a handshake is pending", func() {
+			stalled, err := net.Dial("tcp", address)
+			Expect(err).NotTo(HaveOccurred())
+			defer stalled.Close()
+
+			clientCert, err := ca.BuildSignedCertificate("client")
+			Expect(err).NotTo(HaveOccurred())
+			clientTLSCert, err := clientCert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+			clientTLSConfig.Certificates = []tls.Certificate{clientTLSCert}
+			clientTLSConfig.ServerName = "127.0.0.1"
+
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLSConfig}, Timeout: 5 * time.Second}
+			resp, err := client.Get(fmt.Sprintf("https://%s/", address))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when an audit recorder is set", func() {
+			var events chan audit.Event
+
+			BeforeEach(func() {
+				events = make(chan audit.Event, 10)
+				serverOpts = []server.Option{server.WithAuditRecorder(auditRecorderFunc(func(event audit.Event) {
+					events <- event
+				}))}
+			})
+
+			It("records rejected handshakes", func() {
+				conn, err := tls.Dial("tcp", address, clientTLSConfig)
+				if err == nil {
+					_, err = conn.Read(make([]byte, 1))
+					conn.Close()
+				}
+				Expect(err).To(HaveOccurred())
+
+				var event audit.Event
+				Eventually(events).Should(Receive(&event))
+				Expect(event.Type).To(Equal(audit.EventTLSHandshakeFailed))
+				Expect(event.RemoteAddr).To(MatchRegexp(`^127\.0\.0\.1:\d+$`))
+				Expect(event.Reason).NotTo(BeEmpty())
+			})
+		})
+
+		Context("when PROXY protocol is enabled", func() {
+			BeforeEach(func() {
+				serverOpts = []server.Option{server.WithProxyProtocol([]string{"127.0.0.1/32"})}
+				handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Write([]byte(r.RemoteAddr))
+				})
+
+				clientCert, err := ca.BuildSignedCertificate("client")
+				Expect(err).NotTo(HaveOccurred())
+				clientTLSCert, err := clientCert.TLSCertificate()
+				Expect(err).NotTo(HaveOccurred())
+				clientTLSConfig.Certificates = []tls.Certificate{clientTLSCert}
+				clientTLSConfig.ServerName = "127.0.0.1"
+			})
+
+			It("reads the header before the TLS handshake", func() {
+				rawConn, err := net.Dial("tcp", address)
+				Expect(err).NotTo(HaveOccurred())
+				defer rawConn.Close()
+
+				_, err = io.WriteString(rawConn, "PROXY TCP4 192.0.2.10 127.0.0.1 40000 8443\r\n")
+				Expect(err).NotTo(HaveOccurred())
+
+				conn := tls.Client(rawConn, clientTLSConfig)
+				_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: close\r\n\r\n")
+				Expect(err).NotTo(HaveOccurred())
+
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(body)).To(Equal("192.0.2.10:40000"))
+			})
+		})
+	})
+})
+
+type auditRecorderFunc func(audit.Event)
+
+func (f auditRecorderFunc) Record(event audit.Event) { f(event) }
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// tlsHandshakeTimeout bounds how long a client may take to complete the
+// TLS handshake.
+const tlsHandshakeTimeout = 10 * time.Second
+
+// handshakeListener completes the TLS handshake of every connection before
+// it is accepted, so that failed handshakes can be logged with what the
+// client presented. Handshakes run concurrently, a slow client does not hold
+// up the others.
+type handshakeListener struct {
+	net.Listener
+	config        *tls.Config
+	logger        lager.Logger
+	auditRecorder audit.Recorder
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newHandshakeListener(listener net.Listener, config *tls.Config, logger lager.Logger, auditRecorder audit.Recorder) *handshakeListener {
+	l := &handshakeListener{
+		Listener:      listener,
+		config:        config,
+		logger:        logger,
+		auditRecorder: auditRecorder,
+
+		conns: make(chan net.Conn),
+		errs:  make(chan error),
+		done:  make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+func (l *handshakeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *handshakeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *handshakeListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+				continue
+			case <-l.done:
+				return
+			}
+		}
+		go l.handshake(tls.Server(conn, l.config))
+	}
+}
+
+func (l *handshakeListener) handshake(conn *tls.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), tlsHandshakeTimeout)
+	defer cancel()
+
+	if err := conn.HandshakeContext(ctx); err != nil {
+		l.handshakeFailed(conn, err)
+		conn.Close()
+		return
+	}
+
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *handshakeListener) handshakeFailed(conn *tls.Conn, err error) {
+	remoteAddr := conn.RemoteAddr().String()
+	state := conn.ConnectionState()
+
+	data := lager.Data{"remote-addr": remoteAddr}
+	var details map[string]string
+	if state.ServerName != "" {
+		data["server-name"] = state.ServerName
+		details = map[string]string{"server_name": state.ServerName}
+	}
+
+	// the certificate is only kept on the connection once it is verified
+	certificates := state.PeerCertificates
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &verificationErr) {
+		certificates = verificationErr.UnverifiedCertificates
+	}
+	var subject string
+	if len(certificates) > 0 {
+		subject = certificates[0].Subject.String()
+		data["client-certificate-subject"] = subject
+	}
+
+	l.logger.Error("tls-handshake-failed", err, data)
+	l.auditRecorder.Record(audit.Event{
+		Type:       audit.EventTLSHandshakeFailed,
+		Component:  "http-server",
+		Outcome:    audit.OutcomeFailure,
+		RemoteAddr: remoteAddr,
+		Subject:    subject,
+		Reason:     err.Error(),
+		Details:    details,
+	})
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TLSProfile overrides the protocol versions, cipher suites, key exchange
+// curves and ALPN protocols of the HTTPS listener. Empty fields keep the
+// defaults, which only allow TLS 1.2 with ECDHE AES-GCM cipher suites.
+type TLSProfile struct {
+	MinTLSVersion    string   `json:"min_tls_version,omitempty"`
+	CipherSuites     []string `json:"cipher_suites,omitempty"`
+	CurvePreferences []string `json:"curve_preferences,omitempty"`
+	ALPN             []string `json:"alpn,omitempty"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var curves = map[string]tls.CurveID{}
+
+func init() {
+	for _, curve := range []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521} {
+		curves[curve.String()] = curve
+	}
+}
+
+var alpnProtocols = []string{"h2", "http/1.1"}
+
+// http2CipherSuites are the cipher suites RFC 7540 requires HTTP/2 over
+// TLS 1.2 to support.
+var http2CipherSuites = []uint16{
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+}
+
+// Validate rejects unknown names as well as versions, cipher suites and
+// combinations that are not safe to offer.
+func (p TLSProfile) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// Apply overrides the settings of config that are set in the profile.
+func (p TLSProfile) Apply(config *tls.Config) error {
+	compiled, err := p.compile()
+	if err != nil {
+		return err
+	}
+
+	if compiled.minVersion != 0 {
+		config.MinVersion = compiled.minVersion
+		config.MaxVersion = tls.VersionTLS13
+	}
+	if len(compiled.cipherSuites) > 0 {
+		config.CipherSuites = compiled.cipherSuites
+	}
+	if len(compiled.curves) > 0 {
+		config.CurvePreferences = compiled.curves
+	}
+	if len(p.ALPN) > 0 {
+		config.NextProtos = slices.Clone(p.ALPN)
+	}
+
+	return nil
+}
+
+type compiledTLSProfile struct {
+	minVersion   uint16
+	cipherSuites []uint16
+	curves       []tls.CurveID
+}
+
+func (p TLSProfile) compile() (compiledTLSProfile, error) {
+	var compiled compiledTLSProfile
+
+	if p.MinTLSVersion != "" {
+		version, ok := tlsVersions[p.MinTLSVersion]
+		if !ok {
+			return compiledTLSProfile{}, fmt.Errorf("min_tls_version %q is not supported, use 1.2 or 1.3", p.MinTLSVersion)
+		}
+		compiled.minVersion = version
+	}
+
+	if len(p.CipherSuites) > 0 && compiled.minVersion == tls.VersionTLS13 {
+		return compiledTLSProfile{}, errors.New("cipher_suites cannot be configured when min_tls_version is 1.3")
+	}
+	for _, name := range p.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return compiledTLSProfile{}, err
+		}
+		compiled.cipherSuites = append(compiled.cipherSuites, id)
+	}
+
+	for _, name := range p.CurvePreferences {
+		curve, ok := curves[name]
+		if !ok {
+			return compiledTLSProfile{}, fmt.Errorf("unknown curve %q", name)
+		}
+		compiled.curves = append(compiled.curves, curve)
+	}
+
+	for i, protocol := range p.ALPN {
+		if !slices.Contains(alpnProtocols, protocol) {
+			return compiledTLSProfile{}, fmt.Errorf("unsupported alpn protocol %q, use one of %s", protocol, strings.Join(alpnProtocols, ", "))
+		}
+		if slices.Contains(p.ALPN[:i], protocol) {
+			return compiledTLSProfile{}, fmt.Errorf("duplicate alpn protocol %q", protocol)
+		}
+	}
+
+	if slices.Contains(p.ALPN, "h2") && len(compiled.cipherSuites) > 0 &&
+		!slices.ContainsFunc(compiled.cipherSuites, func(id uint16) bool { return slices.Contains(http2CipherSuites, id) }) {
+		return compiledTLSProfile{}, errors.New("alpn h2 requires cipher_suites to include an ECDHE AES-128-GCM suite")
+	}
+
+	return compiled, nil
+}
+
+func cipherSuite(name string) (uint16, error) {
+	for _, suite := range tls.InsecureCipherSuites() {
+		if suite.Name == name {
+			return 0, fmt.Errorf("cipher suite %s is insecure", name)
+		}
+	}
+
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name != name {
+			continue
+		}
+		if !slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+			return 0, fmt.Errorf("cipher suite %s is only used by TLS 1.3, whose suites cannot be configured", name)
+		}
+		if !strings.HasPrefix(name, "TLS_ECDHE_") {
+			return 0, fmt.Errorf("cipher suite %s does not provide forward secrecy", name)
+		}
+		return suite.ID, nil
+	}
+
+	return 0, fmt.Errorf("unknown cipher suite %q", name)
+}
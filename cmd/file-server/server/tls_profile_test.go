@@ -0,0 +1,188 @@
+package server_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/fileserver/cmd/file-server/server"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"code.cloudfoundry.org/tlsconfig"
+	"code.cloudfoundry.org/tlsconfig/certtest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("TLSProfile", func() {
+	DescribeTable("Validate",
+		func(profile server.TLSProfile, expectedError string) {
+			err := profile.Validate()
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			}
+		},
+		Entry("the defaults", server.TLSProfile{}, ""),
+		Entry("TLS 1.3 only", server.TLSProfile{MinTLSVersion: "1.3"}, ""),
+		Entry("TLS 1.1", server.TLSProfile{MinTLSVersion: "1.1"}, "min_tls_version"),
+		Entry("an unknown cipher suite", server.TLSProfile{CipherSuites: []string{"TLS_FANCY"}}, "unknown cipher suite"),
+		Entry("an insecure cipher suite", server.TLSProfile{CipherSuites: []string{"TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA"}}, "insecure"),
+		Entry("a TLS 1.3 cipher suite", server.TLSProfile{CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}}, "only used by TLS 1.3"),
+		Entry("cipher suites with TLS 1.3 only",
+			server.TLSProfile{MinTLSVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			"cannot be configured when min_tls_version is 1.3"),
+		Entry("an unknown curve", server.TLSProfile{CurvePreferences: []string{"P-256"}}, "unknown curve"),
+		Entry("an unknown alpn protocol", server.TLSProfile{ALPN: []string{"spdy/3"}}, "unsupported alpn protocol"),
+		Entry("a duplicate alpn protocol", server.TLSProfile{ALPN: []string{"h2", "h2"}}, "duplicate alpn protocol"),
+		Entry("h2 without the cipher suites it requires",
+			server.TLSProfile{ALPN: []string{"h2"}, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}},
+			"alpn h2 requires"),
+		Entry("h2 with the cipher suites it requires",
+			server.TLSProfile{ALPN: []string{"h2"}, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			""),
+	)
+
+	Describe("handshakes", func() {
+		var (
+			profile    server.TLSProfile
+			address    string
+			caCertPool *x509.CertPool
+			process    ifrit.Process
+		)
+
+		BeforeEach(func() {
+			profile = server.TLSProfile{}
+			address = fmt.Sprintf("127.0.0.1:%d", 9282+GinkgoParallelProcess())
+		})
+
+		JustBeforeEach(func() {
+			ca, err := certtest.BuildCA("test-ca")
+			Expect(err).NotTo(HaveOccurred())
+			caCertPool, err = ca.CertPool()
+			Expect(err).NotTo(HaveOccurred())
+			cert, err := ca.BuildSignedCertificate("server", certtest.WithIPs(net.ParseIP("127.0.0.1")))
+			Expect(err).NotTo(HaveOccurred())
+			tlsCert, err := cert.TLSCertificate()
+			Expect(err).NotTo(HaveOccurred())
+
+			serverTLSConfig, err := tlsconfig.Build(
+				tlsconfig.WithInternalServiceDefaults(),
+				tlsconfig.WithIdentity(tlsCert),
+				profile.Apply,
+			).Server()
+			Expect(err).NotTo(HaveOccurred())
+
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			process = ifrit.Invoke(server.NewTLS(lagertest.NewTestLogger("test"), address, handler, serverTLSConfig))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		handshake := func(configure func(*tls.Config)) (tls.ConnectionState, error) {
+			clientTLSConfig := &tls.Config{RootCAs: caCertPool, ServerName: "127.0.0.1"}
+			configure(clientTLSConfig)
+
+			conn, err := tls.Dial("tcp", address, clientTLSConfig)
+			if err != nil {
+				return tls.ConnectionState{}, err
+			}
+			defer conn.Close()
+			return conn.ConnectionState(), nil
+		}
+
+		It("only offers TLS 1.2 by default", func() {
+			state, err := handshake(func(c *tls.Config) {})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(state.Version).To(Equal(uint16(tls.VersionTLS12)))
+		})
+
+		Context("when min_tls_version is 1.2", func() {
+			BeforeEach(func() {
+				profile.MinTLSVersion = "1.2"
+			})
+
+			It("also accepts TLS 1.3", func() {
+				state, err := handshake(func(c *tls.Config) {})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.Version).To(Equal(uint16(tls.VersionTLS13)))
+			})
+		})
+
+		Context("when min_tls_version is 1.3", func() {
+			BeforeEach(func() {
+				profile.MinTLSVersion = "1.3"
+			})
+
+			It("negotiates TLS 1.3", func() {
+				state, err := handshake(func(c *tls.Config) {})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.Version).To(Equal(uint16(tls.VersionTLS13)))
+			})
+
+			It("rejects TLS 1.2 clients", func() {
+				_, err := handshake(func(c *tls.Config) { c.MaxVersion = tls.VersionTLS12 })
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when cipher suites are configured", func() {
+			BeforeEach(func() {
+				profile.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"}
+			})
+
+			It("negotiates a configured suite", func() {
+				state, err := handshake(func(c *tls.Config) {})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.CipherSuite).To(Equal(tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256))
+			})
+
+			It("rejects clients that offer none of them", func() {
+				_, err := handshake(func(c *tls.Config) {
+					c.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+				})
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when curve preferences are configured", func() {
+			BeforeEach(func() {
+				profile.CurvePreferences = []string{"CurveP384"}
+			})
+
+			It("accepts clients supporting a configured curve", func() {
+				_, err := handshake(func(c *tls.Config) { c.CurvePreferences = []tls.CurveID{tls.X25519, tls.CurveP384} })
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("rejects clients that support none of them", func() {
+				_, err := handshake(func(c *tls.Config) { c.CurvePreferences = []tls.CurveID{tls.X25519} })
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Context("when alpn protocols are configured", func() {
+			BeforeEach(func() {
+				profile.ALPN = []string{"http/1.1"}
+			})
+
+			It("negotiates a configured protocol", func() {
+				state, err := handshake(func(c *tls.Config) { c.NextProtos = []string{"h2", "http/1.1"} })
+				Expect(err).NotTo(HaveOccurred())
+				Expect(state.NegotiatedProtocol).To(Equal("http/1.1"))
+			})
+
+			It("rejects clients that only speak other protocols", func() {
+				_, err := handshake(func(c *tls.Config) { c.NextProtos = []string{"h2"} })
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
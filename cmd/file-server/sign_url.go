@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/cmd/file-server/config"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
+)
+
+const signURLCommand = "sign-url"
+
+// signURL implements the sign-url subcommand, which prints a presigned URL
+// for a static asset using the keys from the file-server configuration.
+func signURL(args []string) int {
+	flags := flag.NewFlagSet(signURLCommand, flag.ContinueOnError)
+	configPath := flags.String("config", "", "The path to the JSON configuration file.")
+	keyID := flags.String("key-id", "", "The ID of the signing key to use. Defaults to the first configured key.")
+	expiresIn := flags.Duration("expires-in", time.Hour, "How long the URL stays valid.")
+	method := flags.String("method", "GET", "The HTTP method the URL is valid for.")
+	baseURL := flags.String("base-url", "", "The scheme and host to prefix the URL with, e.g. https://file-server.service.cf.internal:8443.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: file-server %s -config <path> [flags] <asset-path>\n", signURLCommand)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	if err := printSignedURL(os.Stdout, *configPath, *keyID, *method, *baseURL, flags.Arg(0), time.Now().Add(*expiresIn)); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to sign url: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printSignedURL(w io.Writer, configPath, keyID, method, baseURL, assetPath string, expires time.Time) error {
+	cfg, err := config.NewFileServerConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.SignedURLKeys) == 0 {
+		return fmt.Errorf("no signed_url_keys configured")
+	}
+	key := cfg.SignedURLKeys[0]
+	if keyID != "" {
+		var ok bool
+		key, ok = cfg.SignedURLKeys.Find(keyID)
+		if !ok {
+			return fmt.Errorf("no signing key with id %q", keyID)
+		}
+	}
+
+	// paths not starting with a slash are relative to the static route
+	if !strings.HasPrefix(assetPath, "/") {
+		staticRoute, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
+		if err != nil {
+			return err
+		}
+		assetPath = staticRoute + assetPath
+	}
+
+	signed := url.URL{
+		Path:     assetPath,
+		RawQuery: signedurl.Sign(key, strings.ToUpper(method), assetPath, expires).Encode(),
+	}
+	_, err = fmt.Fprintln(w, strings.TrimSuffix(baseURL, "/")+signed.String())
+	return err
+}
@@ -0,0 +1,242 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Format is the layout of access log entries.
+type Format string
+
+const (
+	// FormatLager logs entries to the process's lager logger under the
+	// "static-file" session. This is the default.
+	FormatLager Format = "lager"
+	// FormatCombined writes the Apache/NCSA Combined Log Format.
+	FormatCombined Format = "combined"
+	// FormatJSON writes one JSON object per line.
+	FormatJSON Format = "json"
+)
+
+// DefaultMaxBackups is how many rotated files are kept when MaxBackups is
+// not set.
+const DefaultMaxBackups = 5
+
+// Config selects the format and destination of the access log. Combined
+// and JSON entries are written either to File, rotated once it grows past
+// MaxSizeMB, or to syslog at SyslogAddress, given as network://host:port
+// or left empty for the local syslog daemon.
+//
+// SuccessSampleRate is the fraction of 2xx responses logged; 0 logs all of
+// them. Other responses are always logged. Requests to the health routes
+// are only logged with IncludeHealthChecks.
+type Config struct {
+	Format              Format  `json:"format,omitempty"`
+	File                string  `json:"file,omitempty"`
+	MaxSizeMB           int     `json:"max_size_mb,omitempty"`
+	MaxBackups          int     `json:"max_backups,omitempty"`
+	Syslog              bool    `json:"syslog,omitempty"`
+	SyslogAddress       string  `json:"syslog_address,omitempty"`
+	SyslogTag           string  `json:"syslog_tag,omitempty"`
+	SuccessSampleRate   float64 `json:"success_sample_rate,omitempty"`
+	IncludeHealthChecks bool    `json:"include_health_checks,omitempty"`
+}
+
+// Validate checks the format, the destination and the sample rate.
+func (c Config) Validate() error {
+	switch c.Format {
+	case "", FormatLager:
+		if c.File != "" || c.Syslog {
+			return errors.New("access log file and syslog require the combined or json format")
+		}
+	case FormatCombined, FormatJSON:
+		if (c.File == "") == !c.Syslog {
+			return fmt.Errorf("access log format %q requires either a file or syslog", c.Format)
+		}
+	default:
+		return fmt.Errorf("invalid access log format %q", c.Format)
+	}
+
+	if c.MaxSizeMB < 0 || c.MaxBackups < 0 {
+		return errors.New("access log max_size_mb and max_backups cannot be negative")
+	}
+	if c.SyslogAddress != "" {
+		if _, _, err := syslogNetwork(c.SyslogAddress); err != nil {
+			return err
+		}
+	}
+	if c.SuccessSampleRate < 0 || c.SuccessSampleRate > 1 {
+		return fmt.Errorf("access log success_sample_rate %v must be between 0 and 1", c.SuccessSampleRate)
+	}
+
+	return nil
+}
+
+// Logger writes access log entries according to a Config. It is meant to
+// be passed to static.WithAccessLogger.
+type Logger struct {
+	logger     lager.Logger
+	format     Format
+	sampleRate float64
+
+	mu     sync.Mutex
+	output io.WriteCloser
+}
+
+// New opens the destination of the access log. Entries in the lager format
+// go to logger.
+func New(logger lager.Logger, config Config) (*Logger, error) {
+	l := &Logger{
+		logger:     logger,
+		format:     config.Format,
+		sampleRate: config.SuccessSampleRate,
+	}
+	if l.format == "" {
+		l.format = FormatLager
+	}
+
+	switch {
+	case config.File != "":
+		maxBackups := config.MaxBackups
+		if maxBackups == 0 {
+			maxBackups = DefaultMaxBackups
+		}
+		output, err := openRotatingFile(logger, config.File, int64(config.MaxSizeMB)<<20, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		l.output = output
+	case config.Syslog:
+		network, address := "", ""
+		if config.SyslogAddress != "" {
+			var err error
+			network, address, err = syslogNetwork(config.SyslogAddress)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tag := config.SyslogTag
+		if tag == "" {
+			tag = "file-server"
+		}
+		output, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+		if err != nil {
+			return nil, err
+		}
+		l.output = output
+	}
+
+	return l, nil
+}
+
+// LogResponse records a response unless it is a 2xx response left out by
+// sampling.
+func (l *Logger) LogResponse(req *http.Request, start time.Time, data lager.Data) {
+	status, _ := data["status"].(int)
+	if status/100 == 2 && l.sampleRate > 0 && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	var line []byte
+	switch l.format {
+	case FormatCombined:
+		line = combinedLine(req, start, data)
+	case FormatJSON:
+		entry := lager.Data{"timestamp": start.UTC().Format(time.RFC3339Nano)}
+		for k, v := range data {
+			entry[k] = v
+		}
+		var err error
+		line, err = json.Marshal(entry)
+		if err != nil {
+			l.logger.Error("failed-to-encode-access-log-entry", err)
+			return
+		}
+	default:
+		l.logger.Session("static-file").Info("response", data)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.output.Write(append(line, '\n')); err != nil {
+		l.logger.Error("failed-to-write-access-log", err)
+	}
+}
+
+// Close closes the file or syslog connection.
+func (l *Logger) Close() error {
+	if l.output == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.output.Close()
+}
+
+const combinedTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// combinedLine formats
+// host ident user [time] "request" status bytes "referer" "user-agent".
+func combinedLine(req *http.Request, start time.Time, data lager.Data) []byte {
+	host, _ := data["client-ip"].(string)
+	if host == "" {
+		var err error
+		host, _, err = net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+	}
+
+	user := "-"
+	if subject, ok := data["subject"].(string); ok && subject != "" {
+		user = subject
+	}
+
+	size := "-"
+	if n, _ := data["size"].(int); n > 0 {
+		size = fmt.Sprint(n)
+	}
+
+	return fmt.Appendf(nil, "%s - %s [%s] %q %d %s %q %q",
+		orDash(host),
+		user,
+		start.Format(combinedTimeFormat),
+		req.Method+" "+req.URL.RequestURI()+" "+req.Proto,
+		data["status"],
+		size,
+		orDash(req.Referer()),
+		orDash(req.UserAgent()),
+	)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func syslogNetwork(address string) (string, string, error) {
+	network, hostPort, ok := strings.Cut(address, "://")
+	if !ok || hostPort == "" {
+		return "", "", fmt.Errorf("invalid syslog_address %q, use network://host:port", address)
+	}
+	switch network {
+	case "udp", "tcp", "unix", "unixgram":
+	default:
+		return "", "", fmt.Errorf("invalid syslog_address %q, the network must be udp, tcp, unix or unixgram", address)
+	}
+	return network, hostPort, nil
+}
@@ -0,0 +1,227 @@
+package accesslog_test
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/accesslog"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("AccessLog", func() {
+	var (
+		logger  *lagertest.TestLogger
+		config  accesslog.Config
+		logFile string
+		request *http.Request
+		start   time.Time
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		logFile = filepath.Join(GinkgoT().TempDir(), "access.log")
+		config = accesslog.Config{}
+
+		request = httptest.NewRequest("GET", "/v1/static/test?a=b", nil)
+		request.RemoteAddr = "10.0.0.1:51234"
+		request.Header.Set("User-Agent", "rep/1.0")
+		start = time.Date(2024, time.March, 4, 5, 6, 7, 0, time.UTC)
+	})
+
+	newLogger := func() *accesslog.Logger {
+		accessLogger, err := accesslog.New(logger, config)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(accessLogger.Close)
+		return accessLogger
+	}
+
+	readLines := func(path string) []string {
+		contents, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+	}
+
+	Context("by default", func() {
+		It("logs to the lager logger", func() {
+			newLogger().LogResponse(request, start, lager.Data{"status": 200, "size": 5})
+
+			logs := logger.Logs()
+			Expect(logs).To(HaveLen(1))
+			Expect(logs[0].Message).To(Equal("test.static-file.response"))
+			Expect(logs[0].Data).To(HaveKeyWithValue("size", BeNumerically("==", 5)))
+		})
+	})
+
+	Context("with the combined format", func() {
+		BeforeEach(func() {
+			config = accesslog.Config{Format: accesslog.FormatCombined, File: logFile}
+		})
+
+		It("writes one line per response", func() {
+			accessLogger := newLogger()
+			accessLogger.LogResponse(request, start, lager.Data{"status": 200, "size": 5})
+			request.Header.Set("Referer", "https://example.com/")
+			accessLogger.LogResponse(request, start, lager.Data{"status": 404, "size": 0, "subject": "cell-a"})
+
+			Expect(readLines(logFile)).To(Equal([]string{
+				`10.0.0.1 - - [04/Mar/2024:05:06:07 +0000] "GET /v1/static/test?a=b HTTP/1.1" 200 5 "-" "rep/1.0"`,
+				`10.0.0.1 - cell-a [04/Mar/2024:05:06:07 +0000] "GET /v1/static/test?a=b HTTP/1.1" 404 - "https://example.com/" "rep/1.0"`,
+			}))
+			Expect(logger.Logs()).To(BeEmpty())
+		})
+
+		It("uses the resolved client address as the host", func() {
+			newLogger().LogResponse(request, start, lager.Data{"status": 200, "size": 5, "client-ip": "203.0.113.7"})
+
+			Expect(readLines(logFile)[0]).To(HavePrefix("203.0.113.7 - - "))
+		})
+	})
+
+	Context("with the json format", func() {
+		BeforeEach(func() {
+			config = accesslog.Config{Format: accesslog.FormatJSON, File: logFile}
+		})
+
+		It("writes one object per line", func() {
+			newLogger().LogResponse(request, start, lager.Data{"status": 200, "size": 5, "duration": time.Millisecond})
+
+			lines := readLines(logFile)
+			Expect(lines).To(HaveLen(1))
+
+			var entry map[string]interface{}
+			Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+			Expect(entry).To(HaveKeyWithValue("timestamp", "2024-03-04T05:06:07Z"))
+			Expect(entry).To(HaveKeyWithValue("status", BeNumerically("==", 200)))
+			Expect(entry).To(HaveKeyWithValue("duration", BeNumerically("==", time.Millisecond)))
+		})
+	})
+
+	Context("when the file grows past its maximum size", func() {
+		BeforeEach(func() {
+			config = accesslog.Config{Format: accesslog.FormatJSON, File: logFile, MaxSizeMB: 1, MaxBackups: 2}
+		})
+
+		It("rotates it and keeps the configured number of backups", func() {
+			accessLogger := newLogger()
+			data := lager.Data{"status": 200, "padding": strings.Repeat("x", 300<<10)}
+			for i := 0; i < 12; i++ {
+				accessLogger.LogResponse(request, start, data)
+			}
+
+			Expect(readLines(logFile)).To(HaveLen(3))
+			Expect(readLines(logFile + ".1")).To(HaveLen(3))
+			Expect(readLines(logFile + ".2")).To(HaveLen(3))
+			Expect(logFile + ".3").NotTo(BeAnExistingFile())
+		})
+
+		It("keeps writing to the current file when rotating fails", func() {
+			// a file cannot be renamed over a directory
+			Expect(os.MkdirAll(filepath.Join(logFile+".2", "blocked"), 0755)).To(Succeed())
+			accessLogger := newLogger()
+			data := lager.Data{"status": 200, "padding": strings.Repeat("x", 300<<10)}
+			for i := 0; i < 8; i++ {
+				accessLogger.LogResponse(request, start, data)
+			}
+
+			Expect(logger).To(gbytes.Say("failed-to-rotate-access-log"))
+			Expect(readLines(logFile + ".1")).To(HaveLen(3))
+			Expect(readLines(logFile)).To(HaveLen(5))
+
+			Expect(os.RemoveAll(logFile + ".2")).To(Succeed())
+			accessLogger.LogResponse(request, start, data)
+			Expect(readLines(logFile)).To(HaveLen(1))
+			Expect(readLines(logFile + ".1")).To(HaveLen(5))
+			Expect(readLines(logFile + ".2")).To(HaveLen(3))
+		})
+
+		It("appends to an existing file", func() {
+			Expect(os.WriteFile(logFile, []byte("{}\n"), 0640)).To(Succeed())
+			newLogger().LogResponse(request, start, lager.Data{"status": 200})
+
+			Expect(readLines(logFile)).To(HaveLen(2))
+		})
+	})
+
+	Context("when logging to syslog", func() {
+		var conn net.PacketConn
+
+		BeforeEach(func() {
+			var err error
+			conn, err = net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(conn.Close)
+
+			config = accesslog.Config{
+				Format:        accesslog.FormatCombined,
+				Syslog:        true,
+				SyslogAddress: "udp://" + conn.LocalAddr().String(),
+				SyslogTag:     "file-server-access",
+			}
+		})
+
+		It("sends the entries", func() {
+			newLogger().LogResponse(request, start, lager.Data{"status": 200, "size": 5})
+
+			buffer := make([]byte, 4096)
+			Expect(conn.SetReadDeadline(time.Now().Add(5 * time.Second))).To(Succeed())
+			n, _, err := conn.ReadFrom(buffer)
+			Expect(err).NotTo(HaveOccurred())
+
+			message := string(buffer[:n])
+			Expect(message).To(ContainSubstring("file-server-access"))
+			Expect(message).To(ContainSubstring(`"GET /v1/static/test?a=b HTTP/1.1" 200 5`))
+		})
+	})
+
+	Context("when successful responses are sampled", func() {
+		BeforeEach(func() {
+			config = accesslog.Config{Format: accesslog.FormatJSON, File: logFile, SuccessSampleRate: 1e-9}
+		})
+
+		It("leaves most of them out but keeps all other responses", func() {
+			accessLogger := newLogger()
+			for i := 0; i < 100; i++ {
+				accessLogger.LogResponse(request, start, lager.Data{"status": 200})
+			}
+			accessLogger.LogResponse(request, start, lager.Data{"status": 304})
+			accessLogger.LogResponse(request, start, lager.Data{"status": 404})
+
+			lines := readLines(logFile)
+			Expect(lines).To(HaveLen(2))
+			Expect(lines[0]).To(ContainSubstring(`"status":304`))
+			Expect(lines[1]).To(ContainSubstring(`"status":404`))
+		})
+	})
+
+	DescribeTable("Validate",
+		func(config accesslog.Config, expectedError string) {
+			err := config.Validate()
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			}
+		},
+		Entry("the defaults", accesslog.Config{}, ""),
+		Entry("sampled lager entries", accesslog.Config{SuccessSampleRate: 0.1}, ""),
+		Entry("json to a file", accesslog.Config{Format: accesslog.FormatJSON, File: "/tmp/access.log"}, ""),
+		Entry("combined to local syslog", accesslog.Config{Format: accesslog.FormatCombined, Syslog: true}, ""),
+		Entry("an unknown format", accesslog.Config{Format: "common"}, "invalid access log format"),
+		Entry("lager to a file", accesslog.Config{File: "/tmp/access.log"}, "require the combined or json format"),
+		Entry("json without a destination", accesslog.Config{Format: accesslog.FormatJSON}, "requires either a file or syslog"),
+		Entry("json to a file and syslog", accesslog.Config{Format: accesslog.FormatJSON, File: "/tmp/access.log", Syslog: true}, "requires either a file or syslog"),
+		Entry("a negative size", accesslog.Config{Format: accesslog.FormatJSON, File: "/tmp/access.log", MaxSizeMB: -1}, "cannot be negative"),
+		Entry("a syslog address without a network", accesslog.Config{Format: accesslog.FormatJSON, Syslog: true, SyslogAddress: "10.0.0.1:514"}, "invalid syslog_address"),
+		Entry("a sample rate above 1", accesslog.Config{SuccessSampleRate: 2}, "must be between 0 and 1"),
+	)
+})
@@ -0,0 +1 @@
+package accesslog // import "code.cloudfoundry.org/fileserver/handlers/accesslog"
@@ -0,0 +1,87 @@
+package accesslog
+
+import (
+	"fmt"
+	"os"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// rotatingFile appends to path and, once a write would take it past
+// maxSize, renames it to path.1, shifting older files up to
+// path.<maxBackups> and dropping the oldest. A maxSize of 0 never rotates.
+// If rotating fails, writes go on to the current file and rotating is tried
+// again on the next write.
+type rotatingFile struct {
+	logger     lager.Logger
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(logger lager.Logger, path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{logger: logger, path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			r.logger.Error("failed-to-rotate-access-log", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// rotate only replaces the open file once its successor is open, so a
+// failure leaves the current one in place.
+func (r *rotatingFile) rotate() error {
+	for i := r.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(r.backup(i), r.backup(i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	// the file is already gone if opening its successor failed before
+	if err := os.Rename(r.path, r.backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	current := r.file
+	if err := r.open(); err != nil {
+		return err
+	}
+	return current.Close()
+}
+
+func (r *rotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,247 @@
+package admin
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/tedsuo/rata"
+)
+
+const (
+	ListDigestsRoute  = "ListDigests"
+	DigestStatsRoute  = "DigestStats"
+	PurgeDigestsRoute = "PurgeDigests"
+	RehashDigestRoute = "RehashDigest"
+	ListAssetsRoute   = "ListAssets"
+)
+
+// DefaultTopAssets is how many assets ListAssets returns without a top
+// query parameter.
+const DefaultTopAssets = 10
+
+// Routes of the admin API. Digests are addressed by their path relative to
+// the static directory, e.g. /buildpacks/go.zip, given in the path or
+// prefix query parameter. Assets are listed with the top (0 for all), sort
+// and prefix query parameters, e.g. /v1/assets?top=5&sort=bytes.
+var Routes = rata.Routes{
+	{Name: ListDigestsRoute, Method: "GET", Path: "/v1/digests"},
+	{Name: DigestStatsRoute, Method: "GET", Path: "/v1/digests/stats"},
+	{Name: PurgeDigestsRoute, Method: "DELETE", Path: "/v1/digests"},
+	{Name: RehashDigestRoute, Method: "POST", Path: "/v1/digests/rehash"},
+	{Name: ListAssetsRoute, Method: "GET", Path: "/v1/assets"},
+}
+
+// Config enables the admin API on a listener of its own. Clients must
+// present a certificate signed by CAFile and, if Subjects is set, with one
+// of the listed common names or distinguished names.
+type Config struct {
+	ListenAddr string   `json:"listen_addr,omitempty"`
+	CertFile   string   `json:"cert_file,omitempty"`
+	KeyFile    string   `json:"key_file,omitempty"`
+	CAFile     string   `json:"ca_file,omitempty"`
+	Subjects   []string `json:"subjects,omitempty"`
+}
+
+// Enabled reports whether the admin API is served.
+func (c Config) Enabled() bool {
+	return c.ListenAddr != ""
+}
+
+// Validate requires the certificate, key and CA of an enabled admin API.
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		if c.CertFile != "" || c.KeyFile != "" || c.CAFile != "" || len(c.Subjects) > 0 {
+			return errors.New("admin api is configured without a listen_addr")
+		}
+		return nil
+	}
+	if c.CertFile == "" || c.KeyFile == "" || c.CAFile == "" {
+		return errors.New("admin api requires cert_file, key_file and ca_file")
+	}
+	return nil
+}
+
+type handler struct {
+	logger   lager.Logger
+	digests  *static.DigestCache
+	assets   *assetstats.Stats
+	subjects []string
+}
+
+// Option configures optional parts of the admin API.
+type Option func(*handler)
+
+// WithAssetStats serves the download statistics of assets. Without it
+// ListAssets responds with 404.
+func WithAssetStats(stats *assetstats.Stats) Option {
+	return func(h *handler) {
+		h.assets = stats
+	}
+}
+
+// New serves the admin API for digests. Every purge and rehash is logged
+// with the identity of the client that requested it.
+func New(logger lager.Logger, config Config, digests *static.DigestCache, opts ...Option) (http.Handler, error) {
+	h := &handler{
+		logger:   logger.Session("admin"),
+		digests:  digests,
+		subjects: config.Subjects,
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	router, err := rata.NewRouter(Routes, rata.Handlers{
+		ListDigestsRoute:  http.HandlerFunc(h.listDigests),
+		DigestStatsRoute:  http.HandlerFunc(h.digestStats),
+		PurgeDigestsRoute: http.HandlerFunc(h.purgeDigests),
+		RehashDigestRoute: http.HandlerFunc(h.rehashDigest),
+		ListAssetsRoute:   http.HandlerFunc(h.listAssets),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h.authorize(router), nil
+}
+
+func (h *handler) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := clientCert(r)
+		if cert == nil || (len(h.subjects) > 0 &&
+			!slices.Contains(h.subjects, cert.Subject.CommonName) &&
+			!slices.Contains(h.subjects, cert.Subject.String())) {
+			data := lager.Data{"method": r.Method, "uri": r.URL.RequestURI(), "remote-addr": r.RemoteAddr}
+			if cert != nil {
+				data["subject"] = cert.Subject.String()
+			}
+			h.logger.Info("request-denied", data)
+			audit.Record(r, audit.Event{
+				Type:      audit.EventAccessDenied,
+				Component: "admin",
+				Outcome:   audit.OutcomeDenied,
+				Reason:    "client certificate is not allowed to use the admin api",
+			})
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func (h *handler) listDigests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.digests.Entries(r.URL.Query().Get("prefix")))
+}
+
+func (h *handler) digestStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.digests.Stats())
+}
+
+func (h *handler) purgeDigests(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	p, prefix := query.Get("path"), query.Get("prefix")
+	if (p == "") == (prefix == "") {
+		http.Error(w, "either path or prefix is required", http.StatusBadRequest)
+		return
+	}
+
+	var removed int
+	if p != "" {
+		removed = h.digests.Purge(p)
+	} else {
+		removed = h.digests.PurgePrefix(prefix)
+	}
+
+	h.audit(r, "purged-digests", audit.OutcomeSuccess, lager.Data{"path": p, "prefix": prefix, "removed": removed})
+	writeJSON(w, http.StatusOK, map[string]int{"removed": removed})
+}
+
+func (h *handler) rehashDigest(w http.ResponseWriter, r *http.Request) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := h.digests.Rehash(p)
+	if err != nil {
+		h.audit(r, "rehash-failed", audit.OutcomeFailure, lager.Data{"path": p, "error": err.Error()})
+		status := http.StatusUnprocessableEntity
+		if errors.Is(err, fs.ErrNotExist) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	h.audit(r, "rehashed-digest", audit.OutcomeSuccess, lager.Data{"path": entry.Path, "digest": entry.Digest})
+	writeJSON(w, http.StatusOK, entry)
+}
+
+func (h *handler) listAssets(w http.ResponseWriter, r *http.Request) {
+	if h.assets == nil {
+		http.Error(w, "asset stats are not enabled", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	top := DefaultTopAssets
+	if value := query.Get("top"); value != "" {
+		var err error
+		top, err = strconv.Atoi(value)
+		if err != nil || top < 0 {
+			http.Error(w, "top must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	assets, err := h.assets.Top(top, assetstats.SortKey(query.Get("sort")), query.Get("prefix"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, assets)
+}
+
+// audit logs a change made through the admin API together with who made
+// it, and records it in the audit log.
+func (h *handler) audit(r *http.Request, action, outcome string, data lager.Data) {
+	details := map[string]string{"action": action}
+	for k, v := range data {
+		if s := fmt.Sprint(v); s != "" {
+			details[k] = s
+		}
+	}
+	audit.Record(r, audit.Event{
+		Type:      audit.EventAdminOperation,
+		Component: "admin",
+		Outcome:   outcome,
+		Details:   details,
+	})
+
+	data["subject"] = clientCert(r).Subject.String()
+	data["remote-addr"] = r.RemoteAddr
+	h.logger.Info(action, data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
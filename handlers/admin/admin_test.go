@@ -0,0 +1,227 @@
+package admin_test
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/handlers/admin"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Admin", func() {
+	var (
+		logger          *lagertest.TestLogger
+		servedDirectory string
+		digests         *static.DigestCache
+		config          admin.Config
+		opts            []admin.Option
+		cert            *x509.Certificate
+		handler         http.Handler
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		servedDirectory = GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(servedDirectory, "buildpacks"), 0755)).To(Succeed())
+		for _, name := range []string{"buildpacks/go.zip", "buildpacks/ruby.zip", "lifecycle.tgz"} {
+			Expect(os.WriteFile(filepath.Join(servedDirectory, name), []byte(name), 0644)).To(Succeed())
+		}
+
+		digests = static.NewDigestCache()
+		fileServer := static.NewFileServer(servedDirectory, static.WithDigestCache(digests))
+		for _, name := range []string{"buildpacks/go.zip", "buildpacks/ruby.zip", "lifecycle.tgz"} {
+			fileServer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/"+name, nil))
+		}
+
+		config = admin.Config{ListenAddr: "localhost:0"}
+		cert = &x509.Certificate{Subject: pkix.Name{CommonName: "operator"}}
+		opts = nil
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		handler, err = admin.New(logger, config, digests, opts...)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, target, nil)
+		if cert != nil {
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("lists the cached digests", func() {
+		recorder := serve("GET", "/v1/digests?prefix=/buildpacks/")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var entries []static.DigestEntry
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &entries)).To(Succeed())
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0].Path).To(Equal("/buildpacks/go.zip"))
+		Expect(entries[0].Digest).To(HaveLen(64))
+		Expect(entries[0].ComputedAt).NotTo(BeZero())
+	})
+
+	It("reports cache stats", func() {
+		recorder := serve("GET", "/v1/digests/stats")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		var stats static.DigestCacheStats
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &stats)).To(Succeed())
+		Expect(stats).To(Equal(static.DigestCacheStats{Entries: 3, Misses: 3}))
+	})
+
+	Describe("purging", func() {
+		It("purges a path and logs who did it", func() {
+			recorder := serve("DELETE", "/v1/digests?path=/lifecycle.tgz")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(MatchJSON(`{"removed": 1}`))
+			Expect(digests.Entries("")).To(HaveLen(2))
+
+			Expect(logger).To(gbytes.Say("test.admin.purged-digests"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("subject", "CN=operator"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("path", "/lifecycle.tgz"))
+		})
+
+		It("purges a prefix", func() {
+			recorder := serve("DELETE", "/v1/digests?prefix=/buildpacks/")
+			Expect(recorder.Body.String()).To(MatchJSON(`{"removed": 2}`))
+			Expect(digests.Entries("")).To(ConsistOf(HaveField("Path", "/lifecycle.tgz")))
+		})
+
+		It("requires either a path or a prefix", func() {
+			Expect(serve("DELETE", "/v1/digests").Code).To(Equal(http.StatusBadRequest))
+			Expect(serve("DELETE", "/v1/digests?path=/a&prefix=/b").Code).To(Equal(http.StatusBadRequest))
+			Expect(digests.Entries("")).To(HaveLen(3))
+		})
+	})
+
+	Describe("rehashing", func() {
+		It("computes the digest again and logs it", func() {
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "lifecycle.tgz"), []byte("changed"), 0644)).To(Succeed())
+
+			recorder := serve("POST", "/v1/digests/rehash?path=/lifecycle.tgz")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			var entry static.DigestEntry
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &entry)).To(Succeed())
+			sum := sha256.Sum256([]byte("changed"))
+			Expect(entry.Digest).To(Equal(hex.EncodeToString(sum[:])))
+			Expect(digests.Entries("/lifecycle.tgz")).To(ConsistOf(HaveField("Digest", entry.Digest)))
+			Expect(logger).To(gbytes.Say("test.admin.rehashed-digest"))
+		})
+
+		It("responds with 404 for missing files", func() {
+			Expect(serve("POST", "/v1/digests/rehash?path=/missing").Code).To(Equal(http.StatusNotFound))
+			Expect(logger).To(gbytes.Say("test.admin.rehash-failed"))
+		})
+	})
+
+	Describe("assets", func() {
+		It("responds with 404 without asset stats", func() {
+			Expect(serve("GET", "/v1/assets").Code).To(Equal(http.StatusNotFound))
+		})
+
+		Context("with asset stats", func() {
+			BeforeEach(func() {
+				stats := assetstats.New()
+				for i := 0; i < 12; i++ {
+					stats.ObserveAsset(fmt.Sprintf("/buildpacks/%02d.zip", i), http.StatusOK, 100*i, "10.0.0.1")
+				}
+				stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 10, "10.0.0.1")
+				stats.ObserveAsset("/lifecycle.tgz", http.StatusNotModified, 0, "10.0.0.2")
+				opts = append(opts, admin.WithAssetStats(stats))
+			})
+
+			assets := func(target string) []assetstats.Asset {
+				recorder := serve("GET", target)
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+				var assets []assetstats.Asset
+				Expect(json.Unmarshal(recorder.Body.Bytes(), &assets)).To(Succeed())
+				return assets
+			}
+
+			It("lists the top assets by requests", func() {
+				list := assets("/v1/assets")
+				Expect(list).To(HaveLen(admin.DefaultTopAssets))
+				Expect(list[0]).To(Equal(assetstats.Asset{Path: "/lifecycle.tgz", Requests: 2, Bytes: 10, OK: 1, NotModified: 1, UniqueClients: 2}))
+			})
+
+			It("applies the top, sort and prefix parameters", func() {
+				Expect(assets("/v1/assets?top=2&sort=bytes&prefix=/buildpacks/")).To(HaveExactElements(
+					HaveField("Path", "/buildpacks/11.zip"),
+					HaveField("Path", "/buildpacks/10.zip"),
+				))
+				Expect(assets("/v1/assets?top=0")).To(HaveLen(13))
+			})
+
+			It("rejects invalid parameters", func() {
+				Expect(serve("GET", "/v1/assets?top=-1").Code).To(Equal(http.StatusBadRequest))
+				Expect(serve("GET", "/v1/assets?sort=name").Code).To(Equal(http.StatusBadRequest))
+			})
+		})
+	})
+
+	Context("when the client has no verified certificate", func() {
+		BeforeEach(func() {
+			cert = nil
+		})
+
+		It("rejects the request", func() {
+			Expect(serve("DELETE", "/v1/digests?prefix=/").Code).To(Equal(http.StatusForbidden))
+			Expect(digests.Entries("")).To(HaveLen(3))
+			Expect(logger).To(gbytes.Say("test.admin.request-denied"))
+		})
+	})
+
+	Context("when subjects are configured", func() {
+		BeforeEach(func() {
+			config.Subjects = []string{"CN=operator", "release-engineer"}
+		})
+
+		It("accepts matching distinguished names", func() {
+			Expect(serve("GET", "/v1/digests").Code).To(Equal(http.StatusOK))
+		})
+
+		It("rejects other clients", func() {
+			cert.Subject.CommonName = "cell"
+			Expect(serve("GET", "/v1/digests").Code).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	DescribeTable("Validate",
+		func(config admin.Config, expectedError string) {
+			err := config.Validate()
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			}
+		},
+		Entry("disabled", admin.Config{}, ""),
+		Entry("enabled", admin.Config{ListenAddr: "127.0.0.1:8090", CertFile: "cert", KeyFile: "key", CAFile: "ca"}, ""),
+		Entry("enabled without a CA", admin.Config{ListenAddr: "127.0.0.1:8090", CertFile: "cert", KeyFile: "key"}, "requires cert_file, key_file and ca_file"),
+		Entry("files without a listen address", admin.Config{CAFile: "ca"}, "without a listen_addr"),
+	)
+})
@@ -0,0 +1 @@
+package admin // import "code.cloudfoundry.org/fileserver/handlers/admin"
@@ -0,0 +1,220 @@
+package assetstats
+
+import (
+	"errors"
+	"fmt"
+	"hash/maphash"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// SortKey orders the assets returned by Top.
+type SortKey string
+
+const (
+	SortByRequests SortKey = "requests"
+	SortByBytes    SortKey = "bytes"
+	SortByClients  SortKey = "unique_clients"
+)
+
+// Config enables collecting download statistics. With FlushIntervalSeconds
+// set they are also written to the log at that interval.
+type Config struct {
+	Enabled              bool `json:"enabled,omitempty"`
+	FlushIntervalSeconds int  `json:"flush_interval_seconds,omitempty"`
+}
+
+// Validate rejects a negative flush interval and one without statistics to
+// flush.
+func (c Config) Validate() error {
+	if c.FlushIntervalSeconds < 0 {
+		return fmt.Errorf("invalid asset_stats.flush_interval_seconds %d", c.FlushIntervalSeconds)
+	}
+	if c.FlushIntervalSeconds > 0 && !c.Enabled {
+		return errors.New("asset_stats.flush_interval_seconds requires asset_stats to be enabled")
+	}
+	return nil
+}
+
+// Asset holds the counters of a single path relative to the static
+// directory. UniqueClients is an estimate with a standard error of about 3%.
+type Asset struct {
+	Path           string `json:"path"`
+	Requests       uint64 `json:"requests"`
+	Bytes          uint64 `json:"bytes"`
+	OK             uint64 `json:"ok"`
+	PartialContent uint64 `json:"partial_content"`
+	NotModified    uint64 `json:"not_modified"`
+	UniqueClients  uint64 `json:"unique_clients"`
+}
+
+type asset struct {
+	requests, bytes                 uint64
+	ok, partialContent, notModified uint64
+	clients                         hyperLogLog
+}
+
+// Stats counts the downloads of each asset since it was created.
+type Stats struct {
+	seed  maphash.Seed
+	since time.Time
+
+	mu     sync.Mutex
+	assets map[string]*asset
+}
+
+func New() *Stats {
+	return &Stats{
+		seed:   maphash.MakeSeed(),
+		since:  time.Now(),
+		assets: map[string]*asset{},
+	}
+}
+
+// Since is when counting started.
+func (s *Stats) Since() time.Time {
+	return s.since
+}
+
+// ObserveAsset counts a response for path to client, the client's IP
+// address. Only responses for files that exist are counted, so requests for
+// arbitrary paths cannot grow the set of assets.
+func (s *Stats) ObserveAsset(path string, status, size int, client string) {
+	if status != http.StatusOK && status != http.StatusPartialContent && status != http.StatusNotModified {
+		return
+	}
+	clientHash := maphash.String(s.seed, client)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.assets[path]
+	if !ok {
+		a = &asset{}
+		s.assets[path] = a
+	}
+	a.requests++
+	a.bytes += uint64(size)
+	switch status {
+	case http.StatusOK:
+		a.ok++
+	case http.StatusPartialContent:
+		a.partialContent++
+	case http.StatusNotModified:
+		a.notModified++
+	}
+	a.clients.add(clientHash)
+}
+
+// Top returns the n assets under prefix with the highest value of sortBy,
+// ties broken by path. n <= 0 returns all of them.
+func (s *Stats) Top(n int, sortBy SortKey, prefix string) ([]Asset, error) {
+	var value func(Asset) uint64
+	switch sortBy {
+	case SortByRequests, "":
+		value = func(a Asset) uint64 { return a.Requests }
+	case SortByBytes:
+		value = func(a Asset) uint64 { return a.Bytes }
+	case SortByClients:
+		value = func(a Asset) uint64 { return a.UniqueClients }
+	default:
+		return nil, fmt.Errorf("invalid sort %q, must be one of %s, %s or %s", sortBy, SortByRequests, SortByBytes, SortByClients)
+	}
+
+	assets := s.snapshot(prefix)
+	slices.SortFunc(assets, func(a, b Asset) int {
+		if value(a) != value(b) {
+			if value(a) > value(b) {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	if n > 0 && n < len(assets) {
+		assets = assets[:n]
+	}
+	return assets, nil
+}
+
+func (s *Stats) snapshot(prefix string) []Asset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assets := make([]Asset, 0, len(s.assets))
+	for path, a := range s.assets {
+		if !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		assets = append(assets, Asset{
+			Path:           path,
+			Requests:       a.requests,
+			Bytes:          a.bytes,
+			OK:             a.ok,
+			PartialContent: a.partialContent,
+			NotModified:    a.notModified,
+			UniqueClients:  a.clients.estimate(),
+		})
+	}
+	return assets
+}
+
+// Flusher logs the statistics of every asset at a fixed interval so they
+// can be analysed beyond the lifetime of the process. The counters are not
+// reset.
+type Flusher struct {
+	logger   lager.Logger
+	stats    *Stats
+	interval time.Duration
+	clock    clock.Clock
+}
+
+func NewFlusher(logger lager.Logger, stats *Stats, interval time.Duration, clock clock.Clock) *Flusher {
+	return &Flusher{
+		logger:   logger.Session("asset-stats"),
+		stats:    stats,
+		interval: interval,
+		clock:    clock,
+	}
+}
+
+// Run flushes until it is signalled, and once more before returning.
+func (f *Flusher) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	ticker := f.clock.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	close(ready)
+
+	for {
+		select {
+		case <-ticker.C():
+			f.Flush()
+		case <-signals:
+			f.Flush()
+			return nil
+		}
+	}
+}
+
+// Flush logs one line per asset.
+func (f *Flusher) Flush() {
+	since := f.stats.Since().UTC().Format(time.RFC3339)
+	assets, _ := f.stats.Top(0, SortByRequests, "")
+	for _, a := range assets {
+		f.logger.Info("asset", lager.Data{
+			"path":            a.Path,
+			"requests":        a.Requests,
+			"bytes":           a.Bytes,
+			"ok":              a.OK,
+			"partial_content": a.PartialContent,
+			"not_modified":    a.NotModified,
+			"unique_clients":  a.UniqueClients,
+			"since":           since,
+		})
+	}
+}
@@ -0,0 +1,13 @@
+package assetstats_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestAssetStats(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Asset Stats Suite")
+}
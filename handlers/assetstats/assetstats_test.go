@@ -0,0 +1,143 @@
+package assetstats_test
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/assetstats"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+	"github.com/tedsuo/ifrit"
+)
+
+var _ = Describe("AssetStats", func() {
+	var stats *assetstats.Stats
+
+	BeforeEach(func() {
+		stats = assetstats.New()
+	})
+
+	It("counts requests, bytes and statuses per asset", func() {
+		stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 100, "10.0.0.1")
+		stats.ObserveAsset("/lifecycle.tgz", http.StatusPartialContent, 10, "10.0.0.1")
+		stats.ObserveAsset("/lifecycle.tgz", http.StatusNotModified, 0, "10.0.0.2")
+		stats.ObserveAsset("/lifecycle.tgz", http.StatusNotFound, 19, "10.0.0.3")
+
+		assets, err := stats.Top(0, assetstats.SortByRequests, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(assets).To(Equal([]assetstats.Asset{{
+			Path:           "/lifecycle.tgz",
+			Requests:       3,
+			Bytes:          110,
+			OK:             1,
+			PartialContent: 1,
+			NotModified:    1,
+			UniqueClients:  2,
+		}}))
+	})
+
+	It("estimates the number of unique clients", func() {
+		for i := 0; i < 20000; i++ {
+			client := fmt.Sprintf("10.%d.%d.%d", i>>16, (i>>8)&0xff, i&0xff)
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 1, client)
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 1, client)
+		}
+
+		assets, err := stats.Top(1, assetstats.SortByClients, "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(assets[0].Requests).To(BeNumerically("==", 40000))
+		Expect(assets[0].UniqueClients).To(BeNumerically("~", 20000, 2000))
+	})
+
+	Describe("Top", func() {
+		BeforeEach(func() {
+			stats.ObserveAsset("/buildpacks/go.zip", http.StatusOK, 1000, "10.0.0.1")
+			stats.ObserveAsset("/buildpacks/ruby.zip", http.StatusOK, 10, "10.0.0.1")
+			stats.ObserveAsset("/buildpacks/ruby.zip", http.StatusOK, 10, "10.0.0.2")
+			stats.ObserveAsset("/buildpacks/ruby.zip", http.StatusOK, 10, "10.0.0.2")
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 100, "10.0.0.1")
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 100, "10.0.0.2")
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 100, "10.0.0.3")
+		})
+
+		paths := func(n int, sortBy assetstats.SortKey, prefix string) []string {
+			assets, err := stats.Top(n, sortBy, prefix)
+			Expect(err).NotTo(HaveOccurred())
+			var paths []string
+			for _, a := range assets {
+				paths = append(paths, a.Path)
+			}
+			return paths
+		}
+
+		It("sorts by the given key and limits the result", func() {
+			Expect(paths(0, assetstats.SortByRequests, "")).To(Equal([]string{"/buildpacks/ruby.zip", "/lifecycle.tgz", "/buildpacks/go.zip"}))
+			Expect(paths(2, assetstats.SortByBytes, "")).To(Equal([]string{"/buildpacks/go.zip", "/lifecycle.tgz"}))
+			Expect(paths(1, assetstats.SortByClients, "")).To(Equal([]string{"/lifecycle.tgz"}))
+		})
+
+		It("sorts by requests by default and filters by prefix", func() {
+			Expect(paths(10, "", "/buildpacks/")).To(Equal([]string{"/buildpacks/ruby.zip", "/buildpacks/go.zip"}))
+		})
+
+		It("rejects unknown sort keys", func() {
+			_, err := stats.Top(10, "name", "")
+			Expect(err).To(MatchError(ContainSubstring(`invalid sort "name"`)))
+		})
+	})
+
+	Describe("Flusher", func() {
+		var (
+			logger    *lagertest.TestLogger
+			fakeClock *fakeclock.FakeClock
+			process   ifrit.Process
+		)
+
+		BeforeEach(func() {
+			logger = lagertest.NewTestLogger("test")
+			fakeClock = fakeclock.NewFakeClock(time.Now())
+			stats.ObserveAsset("/lifecycle.tgz", http.StatusOK, 100, "10.0.0.1")
+
+			process = ifrit.Invoke(assetstats.NewFlusher(logger, stats, time.Minute, fakeClock))
+		})
+
+		AfterEach(func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive())
+		})
+
+		It("logs every asset at the interval", func() {
+			fakeClock.WaitForWatcherAndIncrement(time.Minute)
+			Eventually(logger).Should(gbytes.Say("test.asset-stats.asset"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("path", "/lifecycle.tgz"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("requests", BeNumerically("==", 1)))
+			Expect(logger.Logs()[0].Data).To(HaveKey("since"))
+		})
+
+		It("flushes once more when it is signalled", func() {
+			process.Signal(os.Interrupt)
+			Eventually(process.Wait()).Should(Receive(BeNil()))
+			Expect(logger).To(gbytes.Say("test.asset-stats.asset"))
+		})
+	})
+
+	DescribeTable("Config.Validate",
+		func(config assetstats.Config, expectedError string) {
+			err := config.Validate()
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			}
+		},
+		Entry("disabled", assetstats.Config{}, ""),
+		Entry("flushed", assetstats.Config{Enabled: true, FlushIntervalSeconds: 60}, ""),
+		Entry("negative interval", assetstats.Config{Enabled: true, FlushIntervalSeconds: -1}, "invalid asset_stats.flush_interval_seconds"),
+		Entry("flushed but disabled", assetstats.Config{FlushIntervalSeconds: 60}, "requires asset_stats to be enabled"),
+	)
+})
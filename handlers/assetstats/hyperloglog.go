@@ -0,0 +1,42 @@
+package assetstats
+
+import (
+	"math"
+	"math/bits"
+)
+
+// hllPrecision gives 1024 one byte registers per asset and a standard
+// error of about 3%.
+const hllPrecision = 10
+
+// hyperLogLog estimates the number of distinct hashes added to it.
+type hyperLogLog struct {
+	registers [1 << hllPrecision]uint8
+}
+
+func (h *hyperLogLog) add(hash uint64) {
+	index := hash >> (64 - hllPrecision)
+	// the guard bit bounds the rank when the remaining bits are all zero
+	rank := uint8(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
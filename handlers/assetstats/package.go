@@ -0,0 +1 @@
+package assetstats // import "code.cloudfoundry.org/fileserver/handlers/assetstats"
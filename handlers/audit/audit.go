@@ -0,0 +1,156 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// EventType names a kind of security relevant event. The values are part
+// of the log format and do not change.
+type EventType string
+
+const (
+	EventTLSHandshakeFailed EventType = "tls_handshake_failed"
+	EventAccessDenied       EventType = "access_denied"
+	EventPathTraversal      EventType = "path_traversal"
+	EventDirectoryAccess    EventType = "directory_access"
+	EventAdminOperation     EventType = "admin_operation"
+	EventConfigReload       EventType = "config_reload"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// Event is one line of the audit log. Component is the part of the server
+// reporting it, e.g. "authorization" or "cert-reloader".
+type Event struct {
+	Time       time.Time         `json:"timestamp"`
+	Type       EventType         `json:"event"`
+	Component  string            `json:"component"`
+	Outcome    string            `json:"outcome"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Subject    string            `json:"subject,omitempty"`
+	Method     string            `json:"method,omitempty"`
+	URI        string            `json:"uri,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Reason     string            `json:"reason,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+}
+
+// Recorder receives audit events.
+type Recorder interface {
+	Record(Event)
+}
+
+// Discard drops all events.
+var Discard Recorder = discard{}
+
+type discard struct{}
+
+func (discard) Record(Event) {}
+
+// Config enables the audit log, written as JSON lines to File. The file is
+// only ever appended to, so it can be rotated with copytruncate.
+type Config struct {
+	File string `json:"file,omitempty"`
+}
+
+// Enabled reports whether events are recorded.
+func (c Config) Enabled() bool {
+	return c.File != ""
+}
+
+// Log appends events to a file.
+type Log struct {
+	logger lager.Logger
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// New opens the audit log file. Events that cannot be written are reported
+// to logger.
+func New(logger lager.Logger, config Config) (*Log, error) {
+	file, err := os.OpenFile(config.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Log{logger: logger.Session("audit"), file: file}, nil
+}
+
+// Record writes event as a single line, setting its time if it has none.
+func (l *Log) Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	event.Time = event.Time.UTC()
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		l.logger.Error("failed-to-encode-event", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.logger.Error("failed-to-write-event", err, lager.Data{"event": event.Type})
+	}
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+type recorderKey struct{}
+
+type requestIDKey struct{}
+
+// Handler makes recorder available to Record for the requests next
+// serves.
+func Handler(recorder Recorder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), recorderKey{}, recorder)))
+	})
+}
+
+// WithRequestID returns a copy of ctx carrying the ID that Record adds to
+// events about the request.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// Record fills in the client and request details of event from r and
+// passes it to the recorder Handler attached to r. Without one the event
+// is dropped.
+func Record(r *http.Request, event Event) {
+	recorder, ok := r.Context().Value(recorderKey{}).(Recorder)
+	if !ok {
+		return
+	}
+
+	event.RemoteAddr = r.RemoteAddr
+	event.Method = r.Method
+	// handlers below http.StripPrefix see a shortened URL
+	event.URI = r.RequestURI
+	if event.URI == "" {
+		event.URI = r.URL.RequestURI()
+	}
+	event.RequestID, _ = r.Context().Value(requestIDKey{}).(string)
+	if event.Subject == "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		event.Subject = r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+	recorder.Record(event)
+}
@@ -0,0 +1,112 @@
+package audit_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type fakeRecorder struct {
+	events []audit.Event
+}
+
+func (r *fakeRecorder) Record(event audit.Event) {
+	r.events = append(r.events, event)
+}
+
+var _ = Describe("Audit", func() {
+	Describe("Log", func() {
+		var (
+			path string
+			log  *audit.Log
+		)
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "audit.log")
+			Expect(os.WriteFile(path, []byte("{\"event\":\"earlier\"}\n"), 0600)).To(Succeed())
+
+			var err error
+			log, err = audit.New(lagertest.NewTestLogger("test"), audit.Config{File: path})
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(log.Close)
+		})
+
+		It("appends events as JSON lines with stable field names", func() {
+			log.Record(audit.Event{
+				Time:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+				Type:       audit.EventAccessDenied,
+				Component:  "authorization",
+				Outcome:    audit.OutcomeDenied,
+				RemoteAddr: "10.0.0.1:4242",
+				Reason:     "no matching rule",
+				Details:    map[string]string{"rule": "/v1/static/a/"},
+			})
+			log.Record(audit.Event{Type: audit.EventConfigReload, Component: "jwks", Outcome: audit.OutcomeSuccess})
+
+			contents, err := os.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+			Expect(lines).To(HaveLen(3))
+			Expect(lines[1]).To(MatchJSON(`{
+				"timestamp": "2024-05-01T10:00:00Z",
+				"event": "access_denied",
+				"component": "authorization",
+				"outcome": "denied",
+				"remote_addr": "10.0.0.1:4242",
+				"reason": "no matching rule",
+				"details": {"rule": "/v1/static/a/"}
+			}`))
+
+			var event map[string]any
+			Expect(json.Unmarshal([]byte(lines[2]), &event)).To(Succeed())
+			Expect(event).To(HaveKeyWithValue("event", "config_reload"))
+			Expect(event).To(HaveKey("timestamp"))
+		})
+	})
+
+	Describe("Record", func() {
+		var request *http.Request
+
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/../secret", nil)
+			cert := &x509.Certificate{Subject: pkix.Name{CommonName: "cell"}}
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			request = request.WithContext(audit.WithRequestID(request.Context(), "some-request-id"))
+		})
+
+		It("fills in the request details for the recorder attached by Handler", func() {
+			recorder := &fakeRecorder{}
+			handler := audit.Handler(recorder, http.StripPrefix("/v1/static", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				audit.Record(r, audit.Event{Type: audit.EventPathTraversal, Component: "static", Outcome: audit.OutcomeDenied})
+			})))
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(recorder.events).To(ConsistOf(audit.Event{
+				Type:       audit.EventPathTraversal,
+				Component:  "static",
+				Outcome:    audit.OutcomeDenied,
+				RemoteAddr: request.RemoteAddr,
+				Subject:    "CN=cell",
+				Method:     "GET",
+				URI:        "/v1/static/../secret",
+				RequestID:  "some-request-id",
+			}))
+		})
+
+		It("drops events for requests without a recorder", func() {
+			Expect(func() { audit.Record(request, audit.Event{Type: audit.EventAccessDenied}) }).NotTo(Panic())
+		})
+	})
+})
@@ -0,0 +1,148 @@
+package authorization
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Rule restricts the URLs below PathPrefix to clients presenting a
+// certificate that matches at least one of the listed identities. Subjects
+// match either the common name or the full distinguished name of the
+// certificate, SANs match any DNS, IP, URI or email SAN.
+type Rule struct {
+	PathPrefix          string   `json:"path_prefix"`
+	Subjects            []string `json:"subjects,omitempty"`
+	SANs                []string `json:"sans,omitempty"`
+	OrganizationalUnits []string `json:"organizational_units,omitempty"`
+}
+
+type Rules []Rule
+
+// Validate checks that every rule has an absolute path prefix and allows at
+// least one identity.
+func (rules Rules) Validate() error {
+	for _, rule := range rules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return fmt.Errorf("authorization rule path prefix %q must be absolute", rule.PathPrefix)
+		}
+		if len(rule.Subjects) == 0 && len(rule.SANs) == 0 && len(rule.OrganizationalUnits) == 0 {
+			return fmt.Errorf("authorization rule for %q does not allow any identity", rule.PathPrefix)
+		}
+	}
+	return nil
+}
+
+// match returns the rule with the longest prefix covering the cleaned path p.
+func (rules Rules) match(p string) (Rule, bool) {
+	var (
+		matched Rule
+		found   bool
+	)
+	for _, rule := range rules {
+		prefix := path.Clean(rule.PathPrefix)
+		if p != prefix && !strings.HasPrefix(p, strings.TrimSuffix(prefix, "/")+"/") {
+			continue
+		}
+		if !found || len(prefix) > len(path.Clean(matched.PathPrefix)) {
+			matched, found = rule, true
+		}
+	}
+	return matched, found
+}
+
+func (r Rule) allows(cert *x509.Certificate) bool {
+	for _, subject := range r.Subjects {
+		if subject == cert.Subject.CommonName || subject == cert.Subject.String() {
+			return true
+		}
+	}
+
+	for _, ou := range cert.Subject.OrganizationalUnit {
+		if slices.Contains(r.OrganizationalUnits, ou) {
+			return true
+		}
+	}
+
+	for _, san := range sans(cert) {
+		if slices.Contains(r.SANs, san) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sans(cert *x509.Certificate) []string {
+	names := append([]string{}, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+type handler struct {
+	rules  Rules
+	next   http.Handler
+	logger lager.Logger
+}
+
+// New wraps next so that requests for paths covered by a rule are only
+// served to clients whose verified certificate matches that rule. All other
+// requests are passed through unchanged.
+func New(logger lager.Logger, rules Rules, next http.Handler) http.Handler {
+	return &handler{
+		rules:  rules,
+		next:   next,
+		logger: logger.Session("authorization"),
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rule, ok := h.rules.match(path.Clean("/" + r.URL.Path))
+	if !ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	var cert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert = r.TLS.VerifiedChains[0][0]
+	}
+
+	if cert != nil && rule.allows(cert) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	data := lager.Data{
+		"method":      r.Method,
+		"uri":         r.URL.RequestURI(),
+		"remote-addr": r.RemoteAddr,
+		"rule":        rule.PathPrefix,
+	}
+	if cert != nil {
+		data["subject"] = cert.Subject.String()
+		data["sans"] = sans(cert)
+	}
+	h.logger.Info("request-denied", data)
+	audit.Record(r, audit.Event{
+		Type:      audit.EventAccessDenied,
+		Component: "authorization",
+		Outcome:   audit.OutcomeDenied,
+		Reason:    "client certificate does not match the rule",
+		Details:   map[string]string{"rule": rule.PathPrefix},
+	})
+
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
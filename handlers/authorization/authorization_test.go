@@ -0,0 +1,228 @@
+package authorization_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/authorization"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Authorization", func() {
+	var (
+		logger   *lagertest.TestLogger
+		rules    authorization.Rules
+		handler  http.Handler
+		recorder *httptest.ResponseRecorder
+		request  *http.Request
+		cert     *x509.Certificate
+		events   []audit.Event
+	)
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		rules = authorization.Rules{
+			{PathPrefix: "/v1/static/segment-a/", Subjects: []string{"cell-a"}},
+			{PathPrefix: "/v1/static/segment-a/shared", OrganizationalUnits: []string{"diego"}},
+			{PathPrefix: "/v1/static/segment-b", SANs: []string{"cell.segment-b.internal", "10.0.0.1", "spiffe://cf/cell-b"}},
+		}
+		cert = &x509.Certificate{
+			Subject: pkix.Name{CommonName: "cell-a", OrganizationalUnit: []string{"segment-a"}},
+		}
+		recorder = httptest.NewRecorder()
+		events = nil
+	})
+
+	JustBeforeEach(func() {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("served"))
+		})
+		handler = audit.Handler(auditRecorderFunc(func(event audit.Event) {
+			events = append(events, event)
+		}), authorization.New(logger, rules, next))
+		if cert != nil {
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}
+		}
+		handler.ServeHTTP(recorder, request)
+	})
+
+	Context("when no rule covers the path", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/lifecycle.tgz", nil)
+			cert = nil
+		})
+
+		It("serves the request without a certificate", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(Equal("served"))
+		})
+	})
+
+	Context("when the certificate subject matches the rule", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-a/lifecycle.tgz", nil)
+		})
+
+		It("serves the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the certificate matches the rule by full distinguished name", func() {
+		BeforeEach(func() {
+			rules[0].Subjects = []string{"CN=cell-a,OU=segment-a"}
+			request = httptest.NewRequest("GET", "/v1/static/segment-a/lifecycle.tgz", nil)
+		})
+
+		It("serves the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when a longer prefix matches", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-a/shared/lifecycle.tgz", nil)
+		})
+
+		It("applies the most specific rule", func() {
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+
+		Context("and the certificate has an allowed OU", func() {
+			BeforeEach(func() {
+				cert.Subject.OrganizationalUnit = []string{"diego"}
+			})
+
+			It("serves the request", func() {
+				Expect(recorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("when the certificate matches the rule by DNS SAN", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-b/lifecycle.tgz", nil)
+			cert.DNSNames = []string{"cell.segment-b.internal"}
+		})
+
+		It("serves the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the certificate matches the rule by IP SAN", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-b/lifecycle.tgz", nil)
+			cert.IPAddresses = []net.IP{net.ParseIP("10.0.0.1")}
+		})
+
+		It("serves the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the certificate matches the rule by URI SAN", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-b/lifecycle.tgz", nil)
+			uri, err := url.Parse("spiffe://cf/cell-b")
+			Expect(err).NotTo(HaveOccurred())
+			cert.URIs = []*url.URL{uri}
+		})
+
+		It("serves the request", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the certificate does not match the rule", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-b/lifecycle.tgz", nil)
+		})
+
+		It("returns 403 and logs the denial", func() {
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("served"))
+
+			Expect(logger).To(gbytes.Say("test.authorization.request-denied"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("rule", "/v1/static/segment-b"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("subject", "CN=cell-a,OU=segment-a"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("uri", "/v1/static/segment-b/lifecycle.tgz"))
+		})
+
+		It("records the denial in the audit log", func() {
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Type).To(Equal(audit.EventAccessDenied))
+			Expect(events[0].Component).To(Equal("authorization"))
+			Expect(events[0].Subject).To(Equal("CN=cell-a,OU=segment-a"))
+			Expect(events[0].Details).To(HaveKeyWithValue("rule", "/v1/static/segment-b"))
+		})
+	})
+
+	Context("when the prefix only matches part of a path segment", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-bb/lifecycle.tgz", nil)
+			cert = nil
+		})
+
+		It("does not apply the rule", func() {
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("when the path is not clean", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static//segment-b/./lifecycle.tgz", nil)
+		})
+
+		It("applies the rule to the cleaned path", func() {
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Context("when the client did not present a certificate", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/v1/static/segment-a/lifecycle.tgz", nil)
+			cert = nil
+		})
+
+		It("returns 403", func() {
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Describe("Validate", func() {
+		BeforeEach(func() {
+			request = httptest.NewRequest("GET", "/", nil)
+		})
+
+		It("accepts valid rules", func() {
+			Expect(rules.Validate()).To(Succeed())
+		})
+
+		It("rejects relative prefixes", func() {
+			rules = authorization.Rules{{PathPrefix: "segment-a", Subjects: []string{"cell-a"}}}
+			Expect(rules.Validate()).To(MatchError(ContainSubstring("must be absolute")))
+		})
+
+		It("rejects rules without identities", func() {
+			rules = authorization.Rules{{PathPrefix: "/v1/static/segment-a"}}
+			Expect(rules.Validate()).To(MatchError(ContainSubstring("does not allow any identity")))
+		})
+	})
+})
+
+type auditRecorderFunc func(audit.Event)
+
+func (f auditRecorderFunc) Record(event audit.Event) { f(event) }
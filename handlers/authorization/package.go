@@ -0,0 +1 @@
+package authorization // import "code.cloudfoundry.org/fileserver/handlers/authorization"
@@ -0,0 +1,225 @@
+package bearer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+var (
+	ErrMissingToken      = errors.New("missing bearer token")
+	ErrUnknownKey        = errors.New("token signed with unknown key")
+	ErrInsufficientScope = errors.New("token is missing a required scope")
+)
+
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// Config enables bearer token authentication. RequiredScopes maps route
+// names to the scopes a token must carry to access them. Only the static
+// route authenticates requests, the health routes are left open for probes.
+type Config struct {
+	JWKSFile       string              `json:"jwks_file,omitempty"`
+	Issuer         string              `json:"issuer,omitempty"`
+	Audience       string              `json:"audience,omitempty"`
+	RequiredScopes map[string][]string `json:"required_scopes,omitempty"`
+}
+
+// Enabled reports whether bearer token authentication is configured.
+func (c Config) Enabled() bool {
+	return c.JWKSFile != ""
+}
+
+// Validate checks that issuer and audience are set whenever a JWKS file is
+// configured, and that scopes are only required for the static route.
+func (c Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if c.Issuer == "" || c.Audience == "" {
+		return errors.New("bearer token authentication requires an issuer and an audience")
+	}
+	return ValidateRequiredScopes(c.RequiredScopes)
+}
+
+// ValidateRequiredScopes rejects scopes for routes other than the static
+// route, which would never be enforced.
+func ValidateRequiredScopes(requiredScopes map[string][]string) error {
+	for route := range requiredScopes {
+		if route != fileserver.StaticRoute {
+			return fmt.Errorf("bearer_auth.required_scopes for route %q would not be enforced, only the %s route authenticates requests", route, fileserver.StaticRoute)
+		}
+	}
+	return nil
+}
+
+// Claims are the claims of a verified token that handlers use.
+type Claims struct {
+	Subject string
+	Scopes  []string
+}
+
+// Verifier checks tokens signed by a key in a KeySet against an issuer
+// and an audience.
+type Verifier struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+	clock    clock.Clock
+}
+
+// NewVerifier returns a Verifier that checks expiry against clock.
+func NewVerifier(keys *KeySet, issuer, audience string, clock clock.Clock) *Verifier {
+	return &Verifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		clock:    clock,
+	}
+}
+
+// Verify checks the signature, issuer, audience and expiry of a serialized
+// token and returns its claims.
+func (v *Verifier) Verify(raw string) (Claims, error) {
+	token, err := jwt.ParseSigned(raw, signatureAlgorithms)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	var keys []jose.JSONWebKey
+	for _, header := range token.Headers {
+		keys = append(keys, v.keys.Key(header.KeyID)...)
+	}
+	if len(keys) == 0 {
+		return Claims{}, ErrUnknownKey
+	}
+
+	var (
+		claims jwt.Claims
+		scopes struct {
+			Scope scopeClaim `json:"scope"`
+		}
+	)
+	err = ErrUnknownKey
+	for _, key := range keys {
+		if err = token.Claims(key.Public(), &claims, &scopes); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return Claims{}, err
+	}
+
+	err = claims.Validate(jwt.Expected{
+		Issuer:      v.issuer,
+		AnyAudience: jwt.Audience{v.audience},
+		Time:        v.clock.Now(),
+	})
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.Expiry == nil {
+		return Claims{}, jwt.ErrExpired
+	}
+
+	return Claims{Subject: claims.Subject, Scopes: scopes.Scope}, nil
+}
+
+// scopeClaim decodes the scope claim both as an array and as the space
+// separated string of RFC 9068.
+type scopeClaim []string
+
+func (s *scopeClaim) UnmarshalJSON(data []byte) error {
+	var scopes []string
+	if err := json.Unmarshal(data, &scopes); err == nil {
+		*s = scopes
+		return nil
+	}
+
+	var scope string
+	if err := json.Unmarshal(data, &scope); err != nil {
+		return err
+	}
+	*s = strings.Fields(scope)
+	return nil
+}
+
+type handler struct {
+	logger         lager.Logger
+	verifier       *Verifier
+	requiredScopes []string
+	next           http.Handler
+}
+
+// New wraps next so that it is only served to requests carrying a valid
+// bearer token with all of the required scopes. The token subject is added
+// to the access log entry of the request.
+func New(logger lager.Logger, verifier *Verifier, requiredScopes []string, next http.Handler) http.Handler {
+	return &handler{
+		logger:         logger.Session("bearer-auth"),
+		verifier:       verifier,
+		requiredScopes: requiredScopes,
+		next:           next,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.authenticate(r)
+	if err != nil {
+		h.deny(w, r, http.StatusUnauthorized, "invalid_token", err)
+		return
+	}
+
+	for _, scope := range h.requiredScopes {
+		if !slices.Contains(claims.Scopes, scope) {
+			h.deny(w, r, http.StatusForbidden, "insufficient_scope", fmt.Errorf("%w: %s", ErrInsufficientScope, scope))
+			return
+		}
+	}
+
+	ctx := static.WithLogData(r.Context(), lager.Data{"subject": claims.Subject})
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (h *handler) authenticate(r *http.Request) (Claims, error) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return Claims{}, ErrMissingToken
+	}
+	return h.verifier.Verify(strings.TrimSpace(token))
+}
+
+func (h *handler) deny(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	h.logger.Info("request-denied", lager.Data{
+		"method":      r.Method,
+		"uri":         r.URL.RequestURI(),
+		"remote-addr": r.RemoteAddr,
+		"status":      status,
+		"reason":      err.Error(),
+	})
+
+	audit.Record(r, audit.Event{
+		Type:      audit.EventAccessDenied,
+		Component: "bearer",
+		Outcome:   audit.OutcomeDenied,
+		Reason:    err.Error(),
+	})
+
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="file-server", error=%q`, code))
+	http.Error(w, http.StatusText(status), status)
+}
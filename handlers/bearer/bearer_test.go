@@ -0,0 +1,287 @@
+package bearer_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/bearer"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+type tokenClaims struct {
+	jwt.Claims
+	Scope []string `json:"scope,omitempty"`
+}
+
+var _ = Describe("Bearer", func() {
+	var (
+		logger     *lagertest.TestLogger
+		fakeClock  *fakeclock.FakeClock
+		jwksDir    string
+		jwksPath   string
+		signingKey *ecdsa.PrivateKey
+		keySet     *bearer.KeySet
+		verifier   *bearer.Verifier
+		claims     tokenClaims
+	)
+
+	writeJWKS := func(kid string, key *ecdsa.PrivateKey) {
+		jwks := jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"},
+		}}
+		contents, err := json.Marshal(jwks)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(jwksPath, contents, 0600)).To(Succeed())
+	}
+
+	sign := func(kid string, key *ecdsa.PrivateKey, claims any) string {
+		signer, err := jose.NewSigner(
+			jose.SigningKey{Algorithm: jose.ES256, Key: key},
+			(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid),
+		)
+		Expect(err).NotTo(HaveOccurred())
+		token, err := jwt.Signed(signer).Claims(claims).Serialize()
+		Expect(err).NotTo(HaveOccurred())
+		return token
+	}
+
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		return key
+	}
+
+	BeforeEach(func() {
+		var err error
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		jwksDir, err = os.MkdirTemp("", "jwks")
+		Expect(err).NotTo(HaveOccurred())
+		jwksPath = filepath.Join(jwksDir, "jwks.json")
+
+		signingKey = newKey()
+		writeJWKS("key-1", signingKey)
+
+		keySet, err = bearer.NewKeySet(logger, jwksPath, bearer.DefaultRefreshInterval, fakeClock)
+		Expect(err).NotTo(HaveOccurred())
+		verifier = bearer.NewVerifier(keySet, "https://uaa.example.com/oauth/token", "file_server", fakeClock)
+
+		claims = tokenClaims{
+			Claims: jwt.Claims{
+				Subject:  "cc-uploader",
+				Issuer:   "https://uaa.example.com/oauth/token",
+				Audience: jwt.Audience{"file_server", "other"},
+				Expiry:   jwt.NewNumericDate(fakeClock.Now().Add(time.Hour)),
+				IssuedAt: jwt.NewNumericDate(fakeClock.Now()),
+			},
+			Scope: []string{"file_server.read"},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(jwksDir)).To(Succeed())
+	})
+
+	Describe("Verifier", func() {
+		It("returns the subject and scopes of a valid token", func() {
+			verified, err := verifier.Verify(sign("key-1", signingKey, claims))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verified.Subject).To(Equal("cc-uploader"))
+			Expect(verified.Scopes).To(ConsistOf("file_server.read"))
+		})
+
+		It("accepts scopes given as a space separated string", func() {
+			verified, err := verifier.Verify(sign("key-1", signingKey, struct {
+				jwt.Claims
+				Scope string `json:"scope"`
+			}{claims.Claims, "file_server.read file_server.write"}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(verified.Scopes).To(ConsistOf("file_server.read", "file_server.write"))
+		})
+
+		It("rejects scopes that are neither a string nor an array", func() {
+			_, err := verifier.Verify(sign("key-1", signingKey, struct {
+				jwt.Claims
+				Scope int `json:"scope"`
+			}{claims.Claims, 42}))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects expired tokens", func() {
+			token := sign("key-1", signingKey, claims)
+			fakeClock.Increment(2 * time.Hour)
+			_, err := verifier.Verify(token)
+			Expect(err).To(MatchError(jwt.ErrExpired))
+		})
+
+		It("rejects tokens without an expiry", func() {
+			claims.Expiry = nil
+			_, err := verifier.Verify(sign("key-1", signingKey, claims))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects tokens from another issuer", func() {
+			claims.Issuer = "https://evil.example.com"
+			_, err := verifier.Verify(sign("key-1", signingKey, claims))
+			Expect(err).To(MatchError(jwt.ErrInvalidIssuer))
+		})
+
+		It("rejects tokens for another audience", func() {
+			claims.Audience = jwt.Audience{"cloud_controller"}
+			_, err := verifier.Verify(sign("key-1", signingKey, claims))
+			Expect(err).To(MatchError(jwt.ErrInvalidAudience))
+		})
+
+		It("rejects tokens signed with an unknown key id", func() {
+			_, err := verifier.Verify(sign("key-2", signingKey, claims))
+			Expect(err).To(MatchError(bearer.ErrUnknownKey))
+		})
+
+		It("rejects tokens signed by another key using a known key id", func() {
+			_, err := verifier.Verify(sign("key-1", newKey(), claims))
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("when the JWKS file changes", func() {
+			var rotatedKey *ecdsa.PrivateKey
+
+			BeforeEach(func() {
+				rotatedKey = newKey()
+				writeJWKS("key-2", rotatedKey)
+				Expect(os.Chtimes(jwksPath, time.Now().Add(time.Minute), time.Now().Add(time.Minute))).To(Succeed())
+			})
+
+			It("picks up the new keys after the refresh interval", func() {
+				token := sign("key-2", rotatedKey, claims)
+				_, err := verifier.Verify(token)
+				Expect(err).To(MatchError(bearer.ErrUnknownKey))
+
+				fakeClock.Increment(bearer.DefaultRefreshInterval)
+				_, err = verifier.Verify(token)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logger).To(gbytes.Say("test.jwks.reloaded"))
+			})
+		})
+
+		Context("when the JWKS file becomes invalid", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(jwksPath, []byte("{{"), 0600)).To(Succeed())
+				Expect(os.Chtimes(jwksPath, time.Now().Add(time.Minute), time.Now().Add(time.Minute))).To(Succeed())
+			})
+
+			It("keeps using the previous keys", func() {
+				fakeClock.Increment(bearer.DefaultRefreshInterval)
+				_, err := verifier.Verify(sign("key-1", signingKey, claims))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(logger).To(gbytes.Say("test.jwks.failed-to-reload"))
+			})
+		})
+	})
+
+	Describe("NewKeySet", func() {
+		It("fails when the JWKS file does not exist", func() {
+			_, err := bearer.NewKeySet(logger, filepath.Join(jwksDir, "missing.json"), bearer.DefaultRefreshInterval, fakeClock)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails when the JWKS file has no keys", func() {
+			Expect(os.WriteFile(jwksPath, []byte(`{"keys": []}`), 0600)).To(Succeed())
+			_, err := bearer.NewKeySet(logger, jwksPath, bearer.DefaultRefreshInterval, fakeClock)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("handler", func() {
+		var (
+			handler  http.Handler
+			recorder *httptest.ResponseRecorder
+			request  *http.Request
+		)
+
+		BeforeEach(func() {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("served"))
+			})
+			handler = bearer.New(logger, verifier, []string{"file_server.read"}, next)
+			recorder = httptest.NewRecorder()
+			request = httptest.NewRequest("GET", "/v1/static/test", nil)
+		})
+
+		It("serves requests with a valid token", func() {
+			request.Header.Set("Authorization", "Bearer "+sign("key-1", signingKey, claims))
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Body.String()).To(Equal("served"))
+		})
+
+		It("returns 401 without a token", func() {
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error="invalid_token"`))
+			Expect(logger).To(gbytes.Say("test.bearer-auth.request-denied"))
+		})
+
+		It("returns 401 for other authorization schemes", func() {
+			request.SetBasicAuth("user", "password")
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("returns 403 when a required scope is missing", func() {
+			claims.Scope = []string{"cloud_controller.read"}
+			request.Header.Set("Authorization", "Bearer "+sign("key-1", signingKey, claims))
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error="insufficient_scope"`))
+		})
+
+		It("returns 403 when a required scope is missing from a space separated scope", func() {
+			request.Header.Set("Authorization", "Bearer "+sign("key-1", signingKey, struct {
+				jwt.Claims
+				Scope string `json:"scope"`
+			}{claims.Claims, "cloud_controller.read"}))
+			handler.ServeHTTP(recorder, request)
+			Expect(recorder.Code).To(Equal(http.StatusForbidden))
+			Expect(recorder.Header().Get("WWW-Authenticate")).To(ContainSubstring(`error="insufficient_scope"`))
+		})
+	})
+
+	Describe("Config", func() {
+		It("is disabled without a JWKS file", func() {
+			Expect(bearer.Config{}.Enabled()).To(BeFalse())
+			Expect(bearer.Config{}.Validate()).To(Succeed())
+		})
+
+		It("requires an issuer and audience when enabled", func() {
+			Expect(bearer.Config{JWKSFile: "/jwks.json", Issuer: "uaa"}.Validate()).To(HaveOccurred())
+			Expect(bearer.Config{JWKSFile: "/jwks.json", Issuer: "uaa", Audience: "file_server"}.Validate()).To(Succeed())
+		})
+
+		It("only allows scopes to be required for the static route", func() {
+			config := bearer.Config{JWKSFile: "/jwks.json", Issuer: "uaa", Audience: "file_server"}
+
+			config.RequiredScopes = map[string][]string{"Static": {"file_server.read"}}
+			Expect(config.Validate()).To(Succeed())
+
+			config.RequiredScopes = map[string][]string{"Readyz": {"file_server.read"}}
+			Expect(config.Validate()).To(MatchError(ContainSubstring(`required_scopes for route "Readyz" would not be enforced`)))
+
+			config.RequiredScopes = map[string][]string{"Unknown": {"file_server.read"}}
+			Expect(config.Validate()).To(HaveOccurred())
+		})
+	})
+})
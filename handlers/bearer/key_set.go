@@ -0,0 +1,133 @@
+package bearer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+	"github.com/go-jose/go-jose/v4"
+)
+
+// DefaultRefreshInterval is how often the JWKS file is checked for changes.
+const DefaultRefreshInterval = 10 * time.Second
+
+// KeySet holds the keys of a JWKS file. The file is checked for changes at
+// most once per refresh interval and reloaded when its modification time or
+// size changes; if the new contents cannot be parsed the previous keys stay
+// in use.
+type KeySet struct {
+	logger          lager.Logger
+	path            string
+	clock           clock.Clock
+	refreshInterval time.Duration
+	audit           audit.Recorder
+
+	mu          sync.Mutex
+	keys        jose.JSONWebKeySet
+	lastChecked time.Time
+	modTime     time.Time
+	size        int64
+}
+
+// KeySetOption configures optional behaviour of a KeySet.
+type KeySetOption func(*KeySet)
+
+// WithAuditRecorder records every reload attempt in recorder.
+func WithAuditRecorder(recorder audit.Recorder) KeySetOption {
+	return func(k *KeySet) {
+		k.audit = recorder
+	}
+}
+
+// NewKeySet loads the JWKS file at path.
+func NewKeySet(logger lager.Logger, path string, refreshInterval time.Duration, clock clock.Clock, opts ...KeySetOption) (*KeySet, error) {
+	k := &KeySet{
+		logger:          logger.Session("jwks", lager.Data{"path": path}),
+		path:            path,
+		clock:           clock,
+		refreshInterval: refreshInterval,
+		audit:           audit.Discard,
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.load(info); err != nil {
+		return nil, err
+	}
+	k.lastChecked = clock.Now()
+
+	return k, nil
+}
+
+// Key returns the keys with the given key ID.
+func (k *KeySet) Key(kid string) []jose.JSONWebKey {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.clock.Since(k.lastChecked) >= k.refreshInterval {
+		k.lastChecked = k.clock.Now()
+		k.refresh()
+	}
+
+	return k.keys.Key(kid)
+}
+
+func (k *KeySet) refresh() {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		k.logger.Error("failed-to-stat", err)
+		return
+	}
+	if info.ModTime().Equal(k.modTime) && info.Size() == k.size {
+		return
+	}
+
+	event := audit.Event{
+		Time:      k.clock.Now(),
+		Type:      audit.EventConfigReload,
+		Component: "jwks",
+		Details:   map[string]string{"path": k.path},
+	}
+
+	if err := k.load(info); err != nil {
+		k.logger.Error("failed-to-reload", err)
+		event.Outcome, event.Reason = audit.OutcomeFailure, err.Error()
+		k.audit.Record(event)
+		return
+	}
+	k.logger.Info("reloaded", lager.Data{"keys": len(k.keys.Keys)})
+	event.Outcome = audit.OutcomeSuccess
+	event.Details["keys"] = strconv.Itoa(len(k.keys.Keys))
+	k.audit.Record(event)
+}
+
+func (k *KeySet) load(info os.FileInfo) error {
+	contents, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.Unmarshal(contents, &keys); err != nil {
+		return fmt.Errorf("invalid jwks file: %w", err)
+	}
+	if len(keys.Keys) == 0 {
+		return fmt.Errorf("jwks file %s contains no keys", k.path)
+	}
+
+	k.keys = keys
+	k.modTime = info.ModTime()
+	k.size = info.Size()
+	return nil
+}
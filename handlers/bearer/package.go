@@ -0,0 +1 @@
+package bearer // import "code.cloudfoundry.org/fileserver/handlers/bearer"
@@ -1,21 +1,227 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
+	"code.cloudfoundry.org/clock"
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
 	"code.cloudfoundry.org/fileserver"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/authorization"
+	"code.cloudfoundry.org/fileserver/handlers/bearer"
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	"code.cloudfoundry.org/fileserver/handlers/ipfilter"
+	"code.cloudfoundry.org/fileserver/handlers/requestid"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
 	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
 	"code.cloudfoundry.org/lager/v3"
 	"github.com/tedsuo/rata"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func New(staticDirectory string, logger lager.Logger) (http.Handler, error) {
+type options struct {
+	staticOptions      []static.Option
+	accessLogger       static.AccessLogger
+	authorizationRules authorization.Rules
+	signingKeys        signedurl.Keys
+	clock              clock.Clock
+	bearerVerifier     *bearer.Verifier
+	requiredScopes     map[string][]string
+	ipFilter           ipfilter.Config
+	metrics            *instrument.Metrics
+	tracerProvider     trace.TracerProvider
+	readinessChecks    []health.Check
+	logHealthChecks    bool
+	auditRecorder      audit.Recorder
+}
+
+// Option configures the handlers returned by New.
+type Option func(*options)
+
+// WithStaticOptions passes the given options on to the static file server.
+func WithStaticOptions(opts ...static.Option) Option {
+	return func(o *options) {
+		o.staticOptions = append(o.staticOptions, opts...)
+	}
+}
+
+// WithAccessLogger records the responses of the static route, including
+// requests rejected by its access checks, and of the health routes if they
+// are logged, through accessLogger.
+func WithAccessLogger(accessLogger static.AccessLogger) Option {
+	return func(o *options) {
+		o.accessLogger = accessLogger
+		o.staticOptions = append(o.staticOptions, static.WithAccessLogger(accessLogger))
+	}
+}
+
+// WithMetronClient emits metrics about the responses of the static route,
+// including requests rejected by its access checks, through client.
+func WithMetronClient(client loggingclient.IngressClient) Option {
+	return WithStaticOptions(static.WithMetronClient(client))
+}
+
+// WithAuthorizationRules restricts static assets below the rules' path
+// prefixes to clients presenting a matching certificate.
+func WithAuthorizationRules(rules authorization.Rules) Option {
+	return func(o *options) {
+		o.authorizationRules = rules
+	}
+}
+
+// WithSignedURLs lets requests carrying a valid signature made with one of
+// keys access any static asset, bypassing the authorization rules.
+func WithSignedURLs(keys signedurl.Keys, clock clock.Clock) Option {
+	return func(o *options) {
+		o.signingKeys = keys
+		o.clock = clock
+	}
+}
+
+// WithBearerAuth requires requests without a signed URL to carry a bearer
+// token accepted by verifier. requiredScopes maps route names to the scopes
+// needed to access them, which is only allowed for the static route.
+func WithBearerAuth(verifier *bearer.Verifier, requiredScopes map[string][]string) Option {
+	return func(o *options) {
+		o.bearerVerifier = verifier
+		o.requiredScopes = requiredScopes
+	}
+}
+
+// WithIPFilter rejects requests from clients not permitted by config before
+// any other check is made.
+func WithIPFilter(config ipfilter.Config) Option {
+	return func(o *options) {
+		o.ipFilter = config
+	}
+}
+
+// WithPrometheusMetrics counts and times the requests of every route and
+// the checksums computed by the static file server in metrics.
+func WithPrometheusMetrics(metrics *instrument.Metrics) Option {
+	return func(o *options) {
+		o.metrics = metrics
+		o.staticOptions = append(o.staticOptions, static.WithHashObserver(metrics))
+	}
+}
+
+// WithTracerProvider records a span for every request and for the work the
+// static file server does to serve it.
+func WithTracerProvider(tracerProvider trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tracerProvider
+		o.staticOptions = append(o.staticOptions, static.WithTracer(tracerProvider.Tracer(tracing.InstrumentationName)))
+	}
+}
+
+// WithReadinessChecks adds checks to the readiness route, which always
+// checks that the static directory is readable.
+func WithReadinessChecks(checks ...health.Check) Option {
+	return func(o *options) {
+		o.readinessChecks = append(o.readinessChecks, checks...)
+	}
+}
+
+// WithHealthCheckLogging records requests to the health routes in the
+// access log. They are left out by default.
+func WithHealthCheckLogging() Option {
+	return func(o *options) {
+		o.logHealthChecks = true
+	}
+}
+
+// WithAuditRecorder records denied requests, path traversal attempts and
+// requests for directories in recorder.
+func WithAuditRecorder(recorder audit.Recorder) Option {
+	return func(o *options) {
+		o.auditRecorder = recorder
+	}
+}
+
+func (o *options) checkAccess(logger lager.Logger, staticRoute string, files http.Handler) (http.Handler, error) {
+	handler := files
+
+	if len(o.authorizationRules) > 0 {
+		for _, rule := range o.authorizationRules {
+			if !strings.HasPrefix(rule.PathPrefix, staticRoute) {
+				return nil, fmt.Errorf("authorization rule path prefix %q is not below %s", rule.PathPrefix, staticRoute)
+			}
+		}
+		handler = authorization.New(logger, o.authorizationRules, handler)
+	}
+
+	if o.bearerVerifier != nil {
+		if err := bearer.ValidateRequiredScopes(o.requiredScopes); err != nil {
+			return nil, err
+		}
+		handler = bearer.New(logger, o.bearerVerifier, o.requiredScopes[fileserver.StaticRoute], handler)
+	}
+
+	if len(o.signingKeys) > 0 {
+		handler = signedurl.New(logger, o.signingKeys, o.clock, files, handler)
+	}
+
+	if o.ipFilter.Enabled() {
+		return ipfilter.New(logger, o.ipFilter, handler)
+	}
+
+	return handler, nil
+}
+
+func New(staticDirectory string, logger lager.Logger, opts ...Option) (http.Handler, error) {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	staticRoute, err := fileserver.Routes.CreatePathForRoute(fileserver.StaticRoute, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	return rata.NewRouter(fileserver.Routes, rata.Handlers{
-		fileserver.StaticRoute: static.New(staticDirectory, staticRoute, logger),
+	// the checks run inside the static file server's access log so that
+	// the requests they reject are logged and counted too
+	var checkErr error
+	staticOptions := append(o.staticOptions, static.WithMiddleware(func(files http.Handler) http.Handler {
+		var checked http.Handler
+		checked, checkErr = o.checkAccess(logger, staticRoute, files)
+		return checked
+	}))
+	handler := static.New(staticDirectory, staticRoute, logger, staticOptions...)
+	if checkErr != nil {
+		return nil, checkErr
+	}
+
+	if o.metrics != nil {
+		handler = o.metrics.Wrap(fileserver.StaticRoute, staticRoute, handler)
+	}
+	if o.tracerProvider != nil {
+		handler = tracing.Wrap(o.tracerProvider, staticRoute, handler)
+	}
+
+	// the health routes answer probes and skip authentication
+	checks := append([]health.Check{health.DirectoryReadable("static-directory", staticDirectory)}, o.readinessChecks...)
+	healthzHandler, readyzHandler := health.Liveness(), health.Readiness(logger, checks...)
+	if o.logHealthChecks {
+		healthzHandler = static.LogResponses(healthzHandler, logger, o.accessLogger)
+		readyzHandler = static.LogResponses(readyzHandler, logger, o.accessLogger)
+	}
+
+	router, err := rata.NewRouter(fileserver.Routes, rata.Handlers{
+		fileserver.StaticRoute:  handler,
+		fileserver.HealthzRoute: healthzHandler,
+		fileserver.ReadyzRoute:  readyzHandler,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	if o.auditRecorder != nil {
+		return requestid.New(audit.Handler(o.auditRecorder, router)), nil
+	}
+	return requestid.New(router), nil
 }
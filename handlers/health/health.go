@@ -0,0 +1,92 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Check is one condition the server needs to be ready. Run returns an
+// error describing why the condition does not hold.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Report is the JSON body of the health endpoints.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a single check. Why a check failed is only
+// logged, as the health endpoints answer any client.
+type CheckResult struct {
+	Status string `json:"status"`
+}
+
+// DirectoryReadable checks that dir can be opened and listed.
+func DirectoryReadable(name, dir string) Check {
+	return Check{
+		Name: name,
+		Run: func() error {
+			d, err := os.Open(dir)
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			if _, err := d.Readdirnames(1); err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// Liveness reports that the process is serving requests.
+func Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, Report{Status: StatusOK})
+	})
+}
+
+// Readiness runs checks on every request and responds with 503 Service
+// Unavailable if any of them fails. Failures are logged to logger.
+func Readiness(logger lager.Logger, checks ...Check) http.Handler {
+	logger = logger.Session("readiness")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Report{Status: StatusOK, Checks: map[string]CheckResult{}}
+		for _, check := range checks {
+			result := CheckResult{Status: StatusOK}
+			if err := check.Run(); err != nil {
+				logger.Error("check-failed", err, lager.Data{"check": check.Name})
+				result = CheckResult{Status: StatusFailed}
+				report.Status = StatusFailed
+			}
+			report.Checks[check.Name] = result
+		}
+
+		status := http.StatusOK
+		if report.Status != StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, report)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,94 @@
+package health_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/fileserver/handlers/health"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var logger *lagertest.TestLogger
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+	})
+
+	serve := func(handler http.Handler) (*httptest.ResponseRecorder, health.Report) {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/readyz", nil))
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+
+		var report health.Report
+		Expect(json.Unmarshal(recorder.Body.Bytes(), &report)).To(Succeed())
+		return recorder, report
+	}
+
+	It("reports liveness", func() {
+		recorder, report := serve(health.Liveness())
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(health.StatusOK))
+	})
+
+	Describe("Readiness", func() {
+		It("reports each check", func() {
+			recorder, report := serve(health.Readiness(logger,
+				health.Check{Name: "first", Run: func() error { return nil }},
+				health.Check{Name: "second", Run: func() error { return nil }},
+			))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(report).To(Equal(health.Report{
+				Status: health.StatusOK,
+				Checks: map[string]health.CheckResult{
+					"first":  {Status: health.StatusOK},
+					"second": {Status: health.StatusOK},
+				},
+			}))
+		})
+
+		It("is unavailable when a check fails", func() {
+			recorder, report := serve(health.Readiness(logger,
+				health.Check{Name: "first", Run: func() error { return nil }},
+				health.Check{Name: "second", Run: func() error { return errors.New("open /var/vcap/store: permission denied") }},
+			))
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+			Expect(report.Status).To(Equal(health.StatusFailed))
+			Expect(report.Checks).To(HaveKeyWithValue("first", health.CheckResult{Status: health.StatusOK}))
+			Expect(report.Checks).To(HaveKeyWithValue("second", health.CheckResult{Status: health.StatusFailed}))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("permission denied"))
+
+			Expect(logger.LogMessages()).To(ConsistOf("test.readiness.check-failed"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("check", "second"))
+			Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("error", "open /var/vcap/store: permission denied"))
+		})
+	})
+
+	Describe("DirectoryReadable", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		It("passes for an empty directory", func() {
+			Expect(health.DirectoryReadable("dir", dir).Run()).To(Succeed())
+		})
+
+		It("fails for a missing directory", func() {
+			Expect(health.DirectoryReadable("dir", filepath.Join(dir, "missing")).Run()).To(MatchError(os.ErrNotExist))
+		})
+
+		It("fails for a file", func() {
+			file := filepath.Join(dir, "file")
+			Expect(os.WriteFile(file, []byte("hello"), 0644)).To(Succeed())
+			Expect(health.DirectoryReadable("dir", file).Run()).To(HaveOccurred())
+		})
+	})
+})
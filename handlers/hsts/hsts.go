@@ -0,0 +1,62 @@
+package hsts
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// minPreloadMaxAge is the shortest max-age browsers accept for inclusion in
+// their preload lists.
+const minPreloadMaxAge = 31536000
+
+// Config describes the Strict-Transport-Security header sent on HTTPS
+// responses. A zero MaxAgeSeconds disables the header.
+type Config struct {
+	MaxAgeSeconds     int  `json:"max_age_seconds"`
+	IncludeSubDomains bool `json:"include_subdomains,omitempty"`
+	Preload           bool `json:"preload,omitempty"`
+}
+
+// Enabled reports whether the header is sent.
+func (c Config) Enabled() bool {
+	return c.MaxAgeSeconds > 0
+}
+
+// Validate checks that preloading is only requested with the directives it
+// requires.
+func (c Config) Validate() error {
+	if c.MaxAgeSeconds < 0 {
+		return errors.New("hsts max age must not be negative")
+	}
+	if c.Preload && (!c.IncludeSubDomains || c.MaxAgeSeconds < minPreloadMaxAge) {
+		return fmt.Errorf("hsts preload requires include_subdomains and a max age of at least %d", minPreloadMaxAge)
+	}
+	return nil
+}
+
+func (c Config) header() string {
+	directives := []string{fmt.Sprintf("max-age=%d", c.MaxAgeSeconds)}
+	if c.IncludeSubDomains {
+		directives = append(directives, "includeSubDomains")
+	}
+	if c.Preload {
+		directives = append(directives, "preload")
+	}
+	return strings.Join(directives, "; ")
+}
+
+// New wraps next so that its responses carry the Strict-Transport-Security
+// header. It returns next unchanged if config is not enabled.
+func New(config Config, next http.Handler) http.Handler {
+	if !config.Enabled() {
+		return next
+	}
+
+	header := config.header()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", header)
+		next.ServeHTTP(w, r)
+	})
+}
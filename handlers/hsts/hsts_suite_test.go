@@ -0,0 +1,13 @@
+package hsts_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestHSTS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "HSTS Suite")
+}
@@ -0,0 +1,49 @@
+package hsts_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/fileserver/handlers/hsts"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HSTS", func() {
+	serve := func(config hsts.Config) http.Header {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+		recorder := httptest.NewRecorder()
+		hsts.New(config, next).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+		return recorder.Header()
+	}
+
+	It("sets the header on every response", func() {
+		Expect(serve(hsts.Config{MaxAgeSeconds: 600}).Get("Strict-Transport-Security")).To(Equal("max-age=600"))
+	})
+
+	It("adds the optional directives", func() {
+		header := serve(hsts.Config{MaxAgeSeconds: 63072000, IncludeSubDomains: true, Preload: true})
+		Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=63072000; includeSubDomains; preload"))
+	})
+
+	It("does not set the header when disabled", func() {
+		Expect(serve(hsts.Config{})).NotTo(HaveKey("Strict-Transport-Security"))
+	})
+
+	DescribeTable("Validate",
+		func(config hsts.Config, valid bool) {
+			if valid {
+				Expect(config.Validate()).To(Succeed())
+			} else {
+				Expect(config.Validate()).NotTo(Succeed())
+			}
+		},
+		Entry("disabled", hsts.Config{}, true),
+		Entry("a negative max age", hsts.Config{MaxAgeSeconds: -1}, false),
+		Entry("preload with the required directives", hsts.Config{MaxAgeSeconds: 31536000, IncludeSubDomains: true, Preload: true}, true),
+		Entry("preload without subdomains", hsts.Config{MaxAgeSeconds: 31536000, Preload: true}, false),
+		Entry("preload with a short max age", hsts.Config{MaxAgeSeconds: 600, IncludeSubDomains: true, Preload: true}, false),
+	)
+})
@@ -0,0 +1 @@
+package hsts // import "code.cloudfoundry.org/fileserver/handlers/hsts"
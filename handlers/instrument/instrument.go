@@ -0,0 +1,175 @@
+package instrument
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Config enables the Prometheus metrics endpoint. It is served on the debug
+// server started with the -debugAddr flag unless ListenAddr is set, in which
+// case it gets a listener of its own. Without either, it is served alone on
+// the debug_address from the config.
+type Config struct {
+	Enabled    bool   `json:"enabled,omitempty"`
+	ListenAddr string `json:"listen_addr,omitempty"`
+}
+
+// Validate rejects a listen address for a disabled endpoint.
+func (c Config) Validate() error {
+	if c.ListenAddr != "" && !c.Enabled {
+		return errors.New("prometheus listen_addr is set but prometheus is not enabled")
+	}
+	return nil
+}
+
+// MetricsPath is where the metrics are served.
+const MetricsPath = "/metrics"
+
+// Metrics collects request, connection and checksum metrics in Prometheus
+// format.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	bytesServed     *prometheus.CounterVec
+	openConnections *prometheus.GaugeVec
+	shaCacheEntries prometheus.Gauge
+	hashDuration    prometheus.Histogram
+}
+
+// New returns metrics registered on a registry of their own, together with
+// the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fileserver_http_requests_total",
+			Help: "HTTP requests by route and status code.",
+		}, []string{"route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "fileserver_http_request_duration_seconds",
+			Help:    "Time taken to serve HTTP requests by route and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "code"}),
+		bytesServed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "fileserver_bytes_served_total",
+			Help: "Bytes served in successful responses by top-level path.",
+		}, []string{"path"}),
+		openConnections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "fileserver_open_connections",
+			Help: "Open client connections by listener.",
+		}, []string{"listener"}),
+		shaCacheEntries: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "fileserver_sha_cache_entries",
+			Help: "Checksums held in the ETag cache.",
+		}),
+		hashDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "fileserver_hash_duration_seconds",
+			Help:    "Time taken to compute the checksum of a file.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 16),
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.bytesServed,
+		m.openConnections,
+		m.shaCacheEntries,
+		m.hashDuration,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Wrap counts and times the requests next serves under route. Bytes are
+// attributed to the first path segment below pathPrefix; only successful
+// responses are counted so that requests for missing files cannot create
+// new series.
+func (m *Metrics) Wrap(route, pathPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		code := strconv.Itoa(recorder.status)
+		m.requests.WithLabelValues(route, code).Inc()
+		m.requestDuration.WithLabelValues(route, code).Observe(time.Since(start).Seconds())
+
+		if recorder.status < http.StatusBadRequest && recorder.size > 0 {
+			m.bytesServed.WithLabelValues(topLevelPath(r.URL.Path, pathPrefix)).Add(float64(recorder.size))
+		}
+	})
+}
+
+// ConnState tracks the open connections of the named listener and is meant
+// to be used as http.Server.ConnState.
+func (m *Metrics) ConnState(listener string) func(net.Conn, http.ConnState) {
+	gauge := m.openConnections.WithLabelValues(listener)
+	return func(_ net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			gauge.Inc()
+		case http.StateHijacked, http.StateClosed:
+			gauge.Dec()
+		}
+	}
+}
+
+// ObserveHash records how long computing a checksum took.
+func (m *Metrics) ObserveHash(duration time.Duration) {
+	m.hashDuration.Observe(duration.Seconds())
+}
+
+// SetShaCacheEntries records the number of cached checksums.
+func (m *Metrics) SetShaCacheEntries(entries int) {
+	m.shaCacheEntries.Set(float64(entries))
+}
+
+func topLevelPath(path, pathPrefix string) string {
+	rest := strings.TrimPrefix(path, pathPrefix)
+	first, _, _ := strings.Cut(strings.TrimPrefix(rest, "/"), "/")
+	return "/" + first
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	size, err := r.ResponseWriter.Write(b)
+	r.size += size
+	return size, err
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,105 @@
+package instrument_test
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/instrument"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Instrument", func() {
+	var metrics *instrument.Metrics
+
+	BeforeEach(func() {
+		metrics = instrument.New()
+	})
+
+	scrape := func() string {
+		recorder := httptest.NewRecorder()
+		metrics.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", instrument.MetricsPath, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		body, err := io.ReadAll(recorder.Body)
+		Expect(err).NotTo(HaveOccurred())
+		return string(body)
+	}
+
+	Describe("Wrap", func() {
+		var handler http.Handler
+
+		BeforeEach(func() {
+			handler = metrics.Wrap("Static", "/v1/static/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v1/static/missing" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte("hello"))
+			}))
+		})
+
+		serve := func(path string) {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		}
+
+		It("counts and times requests by route and status code", func() {
+			serve("/v1/static/buildpacks/ruby.zip")
+			serve("/v1/static/buildpacks/go.zip")
+			serve("/v1/static/missing")
+
+			body := scrape()
+			Expect(body).To(ContainSubstring(`fileserver_http_requests_total{code="200",route="Static"} 2`))
+			Expect(body).To(ContainSubstring(`fileserver_http_requests_total{code="404",route="Static"} 1`))
+			Expect(body).To(ContainSubstring(`fileserver_http_request_duration_seconds_count{code="200",route="Static"} 2`))
+		})
+
+		It("counts the bytes of successful responses by top-level path", func() {
+			serve("/v1/static/buildpacks/ruby.zip")
+			serve("/v1/static/buildpacks/go.zip")
+			serve("/v1/static/test")
+			serve("/v1/static/missing")
+
+			body := scrape()
+			Expect(body).To(ContainSubstring(`fileserver_bytes_served_total{path="/buildpacks"} 10`))
+			Expect(body).To(ContainSubstring(`fileserver_bytes_served_total{path="/test"} 5`))
+			Expect(body).NotTo(ContainSubstring(`path="/missing"`))
+		})
+	})
+
+	Describe("ConnState", func() {
+		It("tracks open connections per listener", func() {
+			connState := metrics.ConnState("https")
+			var conn net.Conn
+
+			connState(conn, http.StateNew)
+			connState(conn, http.StateNew)
+			connState(conn, http.StateActive)
+			connState(conn, http.StateClosed)
+
+			Expect(scrape()).To(ContainSubstring(`fileserver_open_connections{listener="https"} 1`))
+		})
+	})
+
+	It("records checksum computations", func() {
+		metrics.ObserveHash(10 * time.Millisecond)
+		metrics.SetShaCacheEntries(3)
+
+		body := scrape()
+		Expect(body).To(ContainSubstring("fileserver_hash_duration_seconds_count 1"))
+		Expect(body).To(ContainSubstring("fileserver_sha_cache_entries 3"))
+	})
+
+	It("includes the Go runtime metrics", func() {
+		Expect(scrape()).To(ContainSubstring("go_goroutines"))
+	})
+
+	Describe("Config", func() {
+		It("rejects a listen address while disabled", func() {
+			Expect(instrument.Config{ListenAddr: "127.0.0.1:9090"}.Validate()).NotTo(Succeed())
+			Expect(instrument.Config{Enabled: true, ListenAddr: "127.0.0.1:9090"}.Validate()).To(Succeed())
+		})
+	})
+})
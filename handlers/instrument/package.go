@@ -0,0 +1 @@
+package instrument // import "code.cloudfoundry.org/fileserver/handlers/instrument"
@@ -0,0 +1,272 @@
+package ipfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"path"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+// Rules hold CIDRs or single IP addresses. A client is rejected if it
+// matches any Deny entry, or if Allow is not empty and it matches none of
+// its entries.
+type Rules struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// Mount applies additional rules to the URLs below PathPrefix.
+type Mount struct {
+	PathPrefix string `json:"path_prefix"`
+	Rules
+}
+
+// Config holds the global rules, which apply to every request, and the
+// per-mount rules, of which the one with the longest matching prefix applies
+// in addition. When TrustXForwardedFor is set, requests from TrustedProxies
+// are attributed to the client listed in X-Forwarded-For. Requests received
+// on a unix socket have no client address and are not filtered; access to
+// the socket is controlled by its file mode instead.
+type Config struct {
+	Rules
+	Mounts             []Mount  `json:"mounts,omitempty"`
+	TrustedProxies     []string `json:"trusted_proxies,omitempty"`
+	TrustXForwardedFor bool     `json:"trust_x_forwarded_for,omitempty"`
+}
+
+// Enabled reports whether any rule is configured.
+func (c Config) Enabled() bool {
+	if len(c.Allow) > 0 || len(c.Deny) > 0 {
+		return true
+	}
+	for _, mount := range c.Mounts {
+		if len(mount.Allow) > 0 || len(mount.Deny) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that all entries are valid CIDRs or IP addresses and that
+// mount prefixes are absolute.
+func (c Config) Validate() error {
+	_, err := c.compile()
+	return err
+}
+
+type prefixes []netip.Prefix
+
+func parsePrefixes(entries []string) (prefixes, error) {
+	var parsed prefixes
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip address %q: %w", entry, err)
+			}
+			addr = addr.Unmap()
+			parsed = append(parsed, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cidr %q: %w", entry, err)
+		}
+		parsed = append(parsed, prefix.Masked())
+	}
+	return parsed, nil
+}
+
+func (p prefixes) contains(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type compiledRules struct {
+	allow prefixes
+	deny  prefixes
+}
+
+func (r Rules) compile() (compiledRules, error) {
+	allow, err := parsePrefixes(r.Allow)
+	if err != nil {
+		return compiledRules{}, err
+	}
+	deny, err := parsePrefixes(r.Deny)
+	if err != nil {
+		return compiledRules{}, err
+	}
+	return compiledRules{allow: allow, deny: deny}, nil
+}
+
+func (r compiledRules) permits(addr netip.Addr) bool {
+	if r.deny.contains(addr) {
+		return false
+	}
+	return len(r.allow) == 0 || r.allow.contains(addr)
+}
+
+type compiledMount struct {
+	prefix string
+	rules  compiledRules
+}
+
+type filter struct {
+	global         compiledRules
+	mounts         []compiledMount
+	trustedProxies prefixes
+	trustXFF       bool
+}
+
+func (c Config) compile() (*filter, error) {
+	global, err := c.Rules.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &filter{global: global, trustXFF: c.TrustXForwardedFor}
+
+	for _, mount := range c.Mounts {
+		if !strings.HasPrefix(mount.PathPrefix, "/") {
+			return nil, fmt.Errorf("ip filter mount %q must be absolute", mount.PathPrefix)
+		}
+		rules, err := mount.Rules.compile()
+		if err != nil {
+			return nil, err
+		}
+		f.mounts = append(f.mounts, compiledMount{prefix: path.Clean(mount.PathPrefix), rules: rules})
+	}
+
+	f.trustedProxies, err = parsePrefixes(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if f.trustXFF && len(f.trustedProxies) == 0 {
+		return nil, errors.New("trust_x_forwarded_for requires trusted_proxies")
+	}
+
+	return f, nil
+}
+
+// mount returns the rules of the mount with the longest prefix covering the
+// cleaned path p.
+func (f *filter) mount(p string) (compiledMount, bool) {
+	var (
+		matched compiledMount
+		found   bool
+	)
+	for _, mount := range f.mounts {
+		if p != mount.prefix && !strings.HasPrefix(p, strings.TrimSuffix(mount.prefix, "/")+"/") {
+			continue
+		}
+		if !found || len(mount.prefix) > len(matched.prefix) {
+			matched, found = mount, true
+		}
+	}
+	return matched, found
+}
+
+// clientAddr returns the address of the client that sent r. Hops in
+// X-Forwarded-For are only honoured while the request came through trusted
+// proxies, walking the header from the nearest hop backwards.
+func (f *filter) clientAddr(r *http.Request) (netip.Addr, error) {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr := addrPort.Addr().Unmap()
+
+	if !f.trustXFF {
+		return addr, nil
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0 && f.trustedProxies.contains(addr); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+	}
+
+	return addr, nil
+}
+
+type handler struct {
+	logger lager.Logger
+	filter *filter
+	next   http.Handler
+}
+
+// New wraps next so that it is only served to clients permitted by config.
+func New(logger lager.Logger, config Config, next http.Handler) (http.Handler, error) {
+	f, err := config.compile()
+	if err != nil {
+		return nil, err
+	}
+
+	return &handler{
+		logger: logger.Session("ip-filter"),
+		filter: f,
+		next:   next,
+	}, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr); ok {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	addr, err := h.filter.clientAddr(r)
+	if err != nil {
+		h.deny(w, r, "", "unparseable remote address")
+		return
+	}
+
+	if !h.filter.global.permits(addr) {
+		h.deny(w, r, addr.String(), "denied by global rules")
+		return
+	}
+
+	if mount, ok := h.filter.mount(path.Clean("/" + r.URL.Path)); ok && !mount.rules.permits(addr) {
+		h.deny(w, r, addr.String(), fmt.Sprintf("denied by rules for %s", mount.prefix))
+		return
+	}
+
+	h.next.ServeHTTP(w, r.WithContext(static.WithClientIP(r.Context(), addr.String())))
+}
+
+func (h *handler) deny(w http.ResponseWriter, r *http.Request, clientIP, reason string) {
+	h.logger.Info("request-denied", lager.Data{
+		"method":      r.Method,
+		"uri":         r.URL.RequestURI(),
+		"remote-addr": r.RemoteAddr,
+		"client-ip":   clientIP,
+		"reason":      reason,
+	})
+	audit.Record(r, audit.Event{
+		Type:      audit.EventAccessDenied,
+		Component: "ip-filter",
+		Outcome:   audit.OutcomeDenied,
+		Reason:    reason,
+		Details:   map[string]string{"client_ip": clientIP},
+	})
+	http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+}
@@ -0,0 +1,13 @@
+package ipfilter_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestIPFilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "IP Filter Suite")
+}
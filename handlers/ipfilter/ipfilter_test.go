@@ -0,0 +1,154 @@
+package ipfilter_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/fileserver/handlers/ipfilter"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("IPFilter", func() {
+	var (
+		logger   *lagertest.TestLogger
+		config   ipfilter.Config
+		clientIP string
+	)
+
+	serve := func(remoteAddr, path string, forwardedFor ...string) int {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientIP = static.ClientIP(r)
+			w.Write([]byte("served"))
+		})
+		handler, err := ipfilter.New(logger, config, next)
+		Expect(err).NotTo(HaveOccurred())
+
+		request := httptest.NewRequest("GET", path, nil)
+		request.RemoteAddr = remoteAddr
+		for _, hop := range forwardedFor {
+			request.Header.Add("X-Forwarded-For", hop)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		config = ipfilter.Config{
+			Rules: ipfilter.Rules{
+				Allow: []string{"10.0.0.0/16", "2001:db8::/32", "192.168.0.10"},
+				Deny:  []string{"10.0.99.0/24"},
+			},
+			Mounts: []ipfilter.Mount{
+				{PathPrefix: "/v1/static/segment-a", Rules: ipfilter.Rules{Allow: []string{"10.0.1.0/24"}}},
+			},
+		}
+	})
+
+	It("serves clients in the global allow list", func() {
+		Expect(serve("10.0.5.5:1234", "/v1/static/test")).To(Equal(http.StatusOK))
+		Expect(serve("[2001:db8::1]:1234", "/v1/static/test")).To(Equal(http.StatusOK))
+		Expect(serve("192.168.0.10:1234", "/v1/static/test")).To(Equal(http.StatusOK))
+	})
+
+	It("serves clients connected to a unix socket", func() {
+		handler, err := ipfilter.New(logger, config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		Expect(err).NotTo(HaveOccurred())
+
+		request := httptest.NewRequest("GET", "/v1/static/segment-a/test", nil)
+		request.RemoteAddr = "@"
+		localAddr := &net.UnixAddr{Name: "/var/vcap/data/fileserver/fileserver.sock", Net: "unix"}
+		request = request.WithContext(context.WithValue(request.Context(), http.LocalAddrContextKey, localAddr))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+	})
+
+	It("rejects requests with an unparseable remote address", func() {
+		Expect(serve("@", "/v1/static/test")).To(Equal(http.StatusForbidden))
+	})
+
+	It("matches IPv4-mapped IPv6 remote addresses", func() {
+		Expect(serve("[::ffff:10.0.5.5]:1234", "/v1/static/test")).To(Equal(http.StatusOK))
+	})
+
+	It("rejects clients outside of the global allow list", func() {
+		Expect(serve("172.16.0.1:1234", "/v1/static/test")).To(Equal(http.StatusForbidden))
+		Expect(logger).To(gbytes.Say("test.ip-filter.request-denied"))
+		Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("client-ip", "172.16.0.1"))
+	})
+
+	It("lets the deny list win over the allow list", func() {
+		Expect(serve("10.0.99.1:1234", "/v1/static/test")).To(Equal(http.StatusForbidden))
+	})
+
+	It("applies mount rules in addition to the global rules", func() {
+		Expect(serve("10.0.1.5:1234", "/v1/static/segment-a/test")).To(Equal(http.StatusOK))
+		Expect(serve("10.0.5.5:1234", "/v1/static/segment-a/test")).To(Equal(http.StatusForbidden))
+		Expect(serve("10.0.5.5:1234", "/v1/static//segment-a/test")).To(Equal(http.StatusForbidden))
+		Expect(serve("10.0.5.5:1234", "/v1/static/segment-ab/test")).To(Equal(http.StatusOK))
+	})
+
+	It("ignores X-Forwarded-For by default", func() {
+		Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.5.5")).To(Equal(http.StatusForbidden))
+	})
+
+	Context("when X-Forwarded-For is trusted", func() {
+		BeforeEach(func() {
+			config.TrustXForwardedFor = true
+			config.TrustedProxies = []string{"172.16.0.0/24"}
+		})
+
+		It("uses the forwarded client address for requests from trusted proxies", func() {
+			Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.5.5")).To(Equal(http.StatusOK))
+			Expect(clientIP).To(Equal("10.0.5.5"))
+			Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.99.1")).To(Equal(http.StatusForbidden))
+		})
+
+		It("walks through chained trusted proxies", func() {
+			Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.5.5, 172.16.0.2")).To(Equal(http.StatusOK))
+			Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.5.5", "172.16.0.2")).To(Equal(http.StatusOK))
+		})
+
+		It("does not honour hops spoofed in front of an untrusted hop", func() {
+			Expect(serve("172.16.0.1:1234", "/v1/static/test", "10.0.5.5, 172.31.0.1")).To(Equal(http.StatusForbidden))
+		})
+
+		It("ignores X-Forwarded-For from untrusted clients", func() {
+			Expect(serve("10.0.99.1:1234", "/v1/static/test", "10.0.5.5")).To(Equal(http.StatusForbidden))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts valid rules", func() {
+			Expect(config.Validate()).To(Succeed())
+		})
+
+		It("rejects invalid CIDRs", func() {
+			config.Deny = []string{"10.0.0.0/33"}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("invalid cidr")))
+		})
+
+		It("rejects invalid addresses", func() {
+			config.Mounts[0].Allow = []string{"not-an-ip"}
+			Expect(config.Validate()).To(MatchError(ContainSubstring("invalid ip address")))
+		})
+
+		It("rejects relative mounts", func() {
+			config.Mounts[0].PathPrefix = "segment-a"
+			Expect(config.Validate()).To(HaveOccurred())
+		})
+
+		It("requires trusted proxies when trusting X-Forwarded-For", func() {
+			config.TrustXForwardedFor = true
+			Expect(config.Validate()).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1 @@
+package ipfilter // import "code.cloudfoundry.org/fileserver/handlers/ipfilter"
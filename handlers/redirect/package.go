@@ -0,0 +1 @@
+package redirect // import "code.cloudfoundry.org/fileserver/handlers/redirect"
@@ -0,0 +1,116 @@
+package redirect
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Policy decides what the plain HTTP listener does while the HTTPS server
+// is enabled.
+type Policy string
+
+const (
+	// PolicyRedirect redirects every request to the HTTPS listener. This is
+	// the default.
+	PolicyRedirect Policy = "redirect"
+	// PolicyServe serves the same content over plain HTTP, for legacy
+	// clients.
+	PolicyServe Policy = "serve"
+	// PolicyDisabled does not start the plain HTTP listener at all.
+	PolicyDisabled Policy = "disabled"
+)
+
+// Config configures the plain HTTP listener. Status is the redirect status
+// code, 301 or 308; Host replaces the host of the request in the redirect
+// target and may carry its own port.
+type Config struct {
+	Policy Policy `json:"policy,omitempty"`
+	Status int    `json:"status,omitempty"`
+	Host   string `json:"host,omitempty"`
+}
+
+// Validate checks the policy and the redirect status.
+func (c Config) Validate() error {
+	switch c.Policy {
+	case "", PolicyRedirect, PolicyServe, PolicyDisabled:
+	default:
+		return fmt.Errorf("invalid plain http policy %q", c.Policy)
+	}
+
+	switch c.Status {
+	case 0, http.StatusMovedPermanently, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("invalid redirect status %d, use 301 or 308", c.Status)
+	}
+
+	return nil
+}
+
+type handler struct {
+	status    int
+	host      string
+	httpsPort string
+}
+
+// New returns a handler redirecting to the HTTPS listener on httpsAddr.
+// httpsAddr is not used when the configured host carries its own port, so
+// the HTTPS listener may then be a unix socket or an inherited listener.
+func New(config Config, httpsAddr string) (http.Handler, error) {
+	h := &handler{
+		status: config.Status,
+		host:   config.Host,
+	}
+
+	if _, _, err := net.SplitHostPort(config.Host); err != nil {
+		_, h.httpsPort, err = net.SplitHostPort(httpsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid https listen address %q, set plain_http.host to a host:port: %w", httpsAddr, err)
+		}
+	}
+
+	if h.status == 0 {
+		h.status = http.StatusMovedPermanently
+	}
+	return h, nil
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.host == "" && r.Host == "" {
+		http.Error(w, "missing host", http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "https://"+h.targetHost(r.Host)+r.URL.RequestURI(), h.status)
+}
+
+// targetHost returns the configured host, or the host of the request with
+// its port replaced by the HTTPS port. A configured host that carries its
+// own port is used as is.
+func (h *handler) targetHost(requestHost string) string {
+	if h.host != "" {
+		if _, _, err := net.SplitHostPort(h.host); err == nil {
+			return h.host
+		}
+		return h.withHTTPSPort(h.host)
+	}
+
+	hostname, _, err := net.SplitHostPort(requestHost)
+	if err != nil {
+		// no port, possibly a bracketed IPv6 literal
+		hostname = requestHost
+	}
+	return h.withHTTPSPort(hostname)
+}
+
+func (h *handler) withHTTPSPort(hostname string) string {
+	hostname = strings.TrimSuffix(strings.TrimPrefix(hostname, "["), "]")
+	if h.httpsPort != "443" {
+		return net.JoinHostPort(hostname, h.httpsPort)
+	}
+	if strings.Contains(hostname, ":") {
+		return "[" + hostname + "]"
+	}
+	return hostname
+}
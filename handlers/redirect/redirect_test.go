@@ -0,0 +1,106 @@
+package redirect_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"code.cloudfoundry.org/fileserver/handlers/redirect"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Redirect", func() {
+	var (
+		config    redirect.Config
+		httpsAddr string
+	)
+
+	BeforeEach(func() {
+		config = redirect.Config{}
+		httpsAddr = "0.0.0.0:8443"
+	})
+
+	serve := func(host, target string) *httptest.ResponseRecorder {
+		handler, err := redirect.New(config, httpsAddr)
+		Expect(err).NotTo(HaveOccurred())
+
+		request := httptest.NewRequest("GET", target, nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	DescribeTable("redirecting to the HTTPS port",
+		func(host, expectedLocation string) {
+			recorder := serve(host, "/v1/static/test?a=b")
+			Expect(recorder.Code).To(Equal(http.StatusMovedPermanently))
+			Expect(recorder.Header().Get("Location")).To(Equal(expectedLocation))
+		},
+		Entry("a host with a port", "files.example.com:8080", "https://files.example.com:8443/v1/static/test?a=b"),
+		Entry("a host without a port", "files.example.com", "https://files.example.com:8443/v1/static/test?a=b"),
+		Entry("an IPv4 literal", "10.0.0.1:8080", "https://10.0.0.1:8443/v1/static/test?a=b"),
+		Entry("an IPv6 literal with a port", "[fd00::1]:8080", "https://[fd00::1]:8443/v1/static/test?a=b"),
+		Entry("an IPv6 literal without a port", "[fd00::1]", "https://[fd00::1]:8443/v1/static/test?a=b"),
+	)
+
+	Context("when the HTTPS listener uses the default port", func() {
+		BeforeEach(func() {
+			httpsAddr = ":443"
+		})
+
+		It("leaves the port out", func() {
+			Expect(serve("files.example.com:80", "/test").Header().Get("Location")).To(Equal("https://files.example.com/test"))
+			Expect(serve("[fd00::1]:80", "/test").Header().Get("Location")).To(Equal("https://[fd00::1]/test"))
+		})
+	})
+
+	Context("when a target host is configured", func() {
+		It("uses it with the HTTPS port", func() {
+			config.Host = "files.example.com"
+			Expect(serve("10.0.0.1:8080", "/test").Header().Get("Location")).To(Equal("https://files.example.com:8443/test"))
+		})
+
+		It("keeps its own port", func() {
+			config.Host = "files.example.com:9443"
+			Expect(serve("10.0.0.1:8080", "/test").Header().Get("Location")).To(Equal("https://files.example.com:9443/test"))
+		})
+	})
+
+	Context("when a 308 status is configured", func() {
+		It("preserves the method", func() {
+			config.Status = http.StatusPermanentRedirect
+			Expect(serve("files.example.com", "/test").Code).To(Equal(http.StatusPermanentRedirect))
+		})
+	})
+
+	It("rejects requests without a host", func() {
+		Expect(serve("", "/test").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("fails for an invalid HTTPS address", func() {
+		_, err := redirect.New(config, "8443")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("does not need the HTTPS address when the target host has a port", func() {
+		config.Host = "files.example.com:9443"
+		httpsAddr = "unix:///var/vcap/data/fileserver/https.sock"
+		Expect(serve("10.0.0.1:8080", "/test").Header().Get("Location")).To(Equal("https://files.example.com:9443/test"))
+	})
+
+	DescribeTable("Validate",
+		func(config redirect.Config, valid bool) {
+			if valid {
+				Expect(config.Validate()).To(Succeed())
+			} else {
+				Expect(config.Validate()).NotTo(Succeed())
+			}
+		},
+		Entry("the defaults", redirect.Config{}, true),
+		Entry("serving plain HTTP", redirect.Config{Policy: redirect.PolicyServe}, true),
+		Entry("disabling plain HTTP", redirect.Config{Policy: redirect.PolicyDisabled}, true),
+		Entry("an unknown policy", redirect.Config{Policy: "maybe"}, false),
+		Entry("a 302 redirect", redirect.Config{Status: http.StatusFound}, false),
+	)
+})
@@ -0,0 +1 @@
+package requestid // import "code.cloudfoundry.org/fileserver/handlers/requestid"
@@ -0,0 +1,159 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	RequestIDHeader   = "X-Request-Id"
+	TraceparentHeader = "Traceparent"
+	TracestateHeader  = "Tracestate"
+
+	// maxRequestIDLength bounds client supplied IDs so they cannot bloat
+	// the logs.
+	maxRequestIDLength = 128
+)
+
+// TraceContext identifies the span serving a request within a W3C trace.
+// ParentID is the span of the caller and is zero if the request did not
+// carry a valid traceparent.
+type TraceContext struct {
+	TraceID  [16]byte
+	SpanID   [8]byte
+	ParentID [8]byte
+	Flags    byte
+}
+
+// Sampled reports whether the caller records the trace.
+func (t TraceContext) Sampled() bool {
+	return t.Flags&0x01 != 0
+}
+
+// Traceparent formats the context as a version 00 traceparent header.
+func (t TraceContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", t.TraceID, t.SpanID, t.Flags)
+}
+
+type contextKey struct{}
+
+type ids struct {
+	requestID string
+	trace     TraceContext
+}
+
+// FromContext returns the request ID and trace context New attached to ctx.
+func FromContext(ctx context.Context) (string, TraceContext, bool) {
+	v, ok := ctx.Value(contextKey{}).(ids)
+	return v.requestID, v.trace, ok
+}
+
+// New wraps next so that every request has an X-Request-Id and a W3C trace
+// context. Valid values sent by the client are kept, others are generated.
+// Both are echoed in the response headers, with the traceparent naming this
+// server's span, and added to the access log entry and audit events.
+func New(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		trace, ok := parseTraceparent(r.Header.Get(TraceparentHeader))
+		if !ok {
+			rand.Read(trace.TraceID[:])
+		}
+		rand.Read(trace.SpanID[:])
+
+		w.Header().Set(RequestIDHeader, requestID)
+		w.Header().Set(TraceparentHeader, trace.Traceparent())
+		if tracestate := r.Header.Get(TracestateHeader); ok && tracestate != "" {
+			w.Header().Set(TracestateHeader, tracestate)
+		}
+
+		ctx := context.WithValue(r.Context(), contextKey{}, ids{requestID: requestID, trace: trace})
+		ctx = audit.WithRequestID(ctx, requestID)
+		ctx = static.WithLogData(ctx, lager.Data{
+			"request-id": requestID,
+			"trace-id":   hex.EncodeToString(trace.TraceID[:]),
+			"span-id":    hex.EncodeToString(trace.SpanID[:]),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// parseTraceparent accepts version 00 headers, and the first four fields of
+// headers of later versions as the spec requires. The caller's span becomes
+// the parent.
+func parseTraceparent(header string) (TraceContext, bool) {
+	fields := strings.Split(header, "-")
+	if len(fields) < 4 {
+		return TraceContext{}, false
+	}
+	version, traceID, parentID, flags := fields[0], fields[1], fields[2], fields[3]
+	if len(version) != 2 || version == "ff" || !isLowerHex(version) ||
+		(version == "00" && len(fields) != 4) {
+		return TraceContext{}, false
+	}
+
+	var t TraceContext
+	if !decodeHex(t.TraceID[:], traceID) || t.TraceID == [16]byte{} {
+		return TraceContext{}, false
+	}
+	if !decodeHex(t.ParentID[:], parentID) || t.ParentID == [8]byte{} {
+		return TraceContext{}, false
+	}
+	var f [1]byte
+	if !decodeHex(f[:], flags) {
+		return TraceContext{}, false
+	}
+	t.Flags = f[0]
+
+	return t, true
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || !isLowerHex(s) {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+func isLowerHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
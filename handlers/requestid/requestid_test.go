@@ -0,0 +1,140 @@
+package requestid_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"code.cloudfoundry.org/fileserver/handlers/requestid"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RequestID", func() {
+	const (
+		traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID    = "00f067aa0ba902b7"
+		traceparent = "00-" + traceID + "-" + parentID + "-01"
+	)
+
+	var (
+		logger  *lagertest.TestLogger
+		handler http.Handler
+		request *http.Request
+
+		seenRequestID string
+		seenTrace     requestid.TraceContext
+	)
+
+	BeforeEach(func() {
+		servedDirectory := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), 0644)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("test")
+		staticHandler := static.New(servedDirectory, "/v1/static/", logger)
+		handler = requestid.New(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ok bool
+			seenRequestID, seenTrace, ok = requestid.FromContext(r.Context())
+			Expect(ok).To(BeTrue())
+			staticHandler.ServeHTTP(w, r)
+		}))
+		request = httptest.NewRequest("GET", "/v1/static/test", nil)
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	Context("when the request carries no IDs", func() {
+		It("generates a request ID and echoes it", func() {
+			recorder := serve()
+
+			Expect(seenRequestID).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+			Expect(recorder.Header().Get("X-Request-Id")).To(Equal(seenRequestID))
+		})
+
+		It("starts a new trace", func() {
+			recorder := serve()
+
+			Expect(seenTrace.TraceID).NotTo(Equal([16]byte{}))
+			Expect(seenTrace.SpanID).NotTo(Equal([8]byte{}))
+			Expect(seenTrace.ParentID).To(Equal([8]byte{}))
+			Expect(recorder.Header().Get("Traceparent")).To(Equal(seenTrace.Traceparent()))
+		})
+
+		It("generates different IDs for every request", func() {
+			first := serve().Header()
+			second := serve().Header()
+			Expect(first.Get("X-Request-Id")).NotTo(Equal(second.Get("X-Request-Id")))
+			Expect(first.Get("Traceparent")).NotTo(Equal(second.Get("Traceparent")))
+		})
+	})
+
+	Context("when the request carries valid IDs", func() {
+		BeforeEach(func() {
+			request.Header.Set("X-Request-Id", "cell-1:download-42")
+			request.Header.Set("Traceparent", traceparent)
+			request.Header.Set("Tracestate", "vendor=value")
+		})
+
+		It("keeps the request ID", func() {
+			recorder := serve()
+			Expect(seenRequestID).To(Equal("cell-1:download-42"))
+			Expect(recorder.Header().Get("X-Request-Id")).To(Equal("cell-1:download-42"))
+		})
+
+		It("continues the trace with a span of its own", func() {
+			recorder := serve()
+
+			Expect(seenTrace.Sampled()).To(BeTrue())
+			traceparent := recorder.Header().Get("Traceparent")
+			Expect(traceparent).To(HavePrefix("00-" + traceID + "-"))
+			Expect(traceparent).To(HaveSuffix("-01"))
+			Expect(traceparent).NotTo(ContainSubstring(parentID))
+			Expect(recorder.Header().Get("Tracestate")).To(Equal("vendor=value"))
+		})
+
+		It("adds the IDs to the access log entry", func() {
+			serve()
+
+			logs := logger.Logs()
+			Expect(logs).To(HaveLen(1))
+			Expect(logs[0].Data).To(HaveKeyWithValue("request-id", "cell-1:download-42"))
+			Expect(logs[0].Data).To(HaveKeyWithValue("trace-id", traceID))
+			Expect(logs[0].Data).To(HaveKeyWithValue("span-id", MatchRegexp(`^[0-9a-f]{16}$`)))
+		})
+	})
+
+	DescribeTable("replacing invalid IDs",
+		func(header, value string) {
+			request.Header.Set(header, value)
+			recorder := serve()
+			Expect(recorder.Header().Get(header)).NotTo(Equal(value))
+		},
+		Entry("a request ID with spaces", "X-Request-Id", "not a valid id"),
+		Entry("a request ID that is too long", "X-Request-Id", strings.Repeat("a", 129)),
+		Entry("a malformed traceparent", "Traceparent", "00-xyz-"+parentID+"-01"),
+		Entry("an all zero trace ID", "Traceparent", "00-00000000000000000000000000000000-"+parentID+"-01"),
+		Entry("an all zero parent ID", "Traceparent", "00-"+traceID+"-0000000000000000-01"),
+		Entry("an upper case traceparent", "Traceparent", "00-"+strings.ToUpper(traceID)+"-"+parentID+"-01"),
+		Entry("the forbidden version", "Traceparent", "ff-"+traceID+"-"+parentID+"-01"),
+		Entry("version 00 with extra fields", "Traceparent", traceparent+"-extra"),
+	)
+
+	It("accepts later traceparent versions with extra fields", func() {
+		request.Header.Set("Traceparent", "01-"+traceID+"-"+parentID+"-01-extra")
+		serve()
+		Expect(seenTrace.ParentID).NotTo(Equal([8]byte{}))
+	})
+
+	It("does not echo tracestate without a valid traceparent", func() {
+		request.Header.Set("Tracestate", "vendor=value")
+		Expect(serve().Header()).NotTo(HaveKey("Tracestate"))
+	})
+})
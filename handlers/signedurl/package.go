@@ -0,0 +1 @@
+package signedurl // import "code.cloudfoundry.org/fileserver/handlers/signedurl"
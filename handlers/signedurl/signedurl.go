@@ -0,0 +1,165 @@
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	ExpiresParam = "expires"
+	KeyIDParam   = "kid"
+	SigParam     = "sig"
+
+	minSecretLength = 32
+)
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signed url has expired")
+)
+
+// Key is a named HMAC secret. Signatures carry the ID of the key that
+// produced them, so new keys can be added ahead of old ones and the old keys
+// removed once the URLs they signed have expired.
+type Key struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+// Keys are the keys accepted when validating signatures. The first key is
+// used for minting new URLs.
+type Keys []Key
+
+// Validate checks that key IDs are unique and secrets are long enough.
+func (keys Keys) Validate() error {
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if key.ID == "" {
+			return errors.New("signing key is missing an id")
+		}
+		if seen[key.ID] {
+			return fmt.Errorf("duplicate signing key id %q", key.ID)
+		}
+		seen[key.ID] = true
+
+		if len(key.Secret) < minSecretLength {
+			return fmt.Errorf("signing key %q must be at least %d bytes long", key.ID, minSecretLength)
+		}
+	}
+	return nil
+}
+
+// Find returns the key with the given ID.
+func (keys Keys) Find(id string) (Key, bool) {
+	for _, key := range keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+// Sign returns the query parameters granting access to path with the given
+// method until expires.
+func Sign(key Key, method, path string, expires time.Time) url.Values {
+	expiresUnix := strconv.FormatInt(expires.Unix(), 10)
+	return url.Values{
+		ExpiresParam: {expiresUnix},
+		KeyIDParam:   {key.ID},
+		SigParam:     {signature(key, method, path, expiresUnix)},
+	}
+}
+
+// Verify checks the signature parameters in query against method and path.
+func (keys Keys) Verify(method, path string, query url.Values, now time.Time) error {
+	key, ok := keys.Find(query.Get(KeyIDParam))
+	if !ok {
+		return ErrUnknownKey
+	}
+
+	expiresUnix := query.Get(ExpiresParam)
+	expires, err := strconv.ParseInt(expiresUnix, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(key, method, path, expiresUnix)
+	if !hmac.Equal([]byte(expected), []byte(query.Get(SigParam))) {
+		return ErrInvalidSignature
+	}
+
+	if now.Unix() > expires {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+func signature(key Key, method, path, expires string) string {
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", key.ID, method, path, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type handler struct {
+	logger   lager.Logger
+	keys     Keys
+	clock    clock.Clock
+	signed   http.Handler
+	unsigned http.Handler
+}
+
+// New returns a handler that serves requests carrying a valid signature with
+// signed and requests without any signature with unsigned. Requests with an
+// invalid or expired signature are rejected with 403.
+func New(logger lager.Logger, keys Keys, clock clock.Clock, signed, unsigned http.Handler) http.Handler {
+	return &handler{
+		logger:   logger.Session("signed-url"),
+		keys:     keys,
+		clock:    clock,
+		signed:   signed,
+		unsigned: unsigned,
+	}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !query.Has(SigParam) {
+		h.unsigned.ServeHTTP(w, r)
+		return
+	}
+
+	err := h.keys.Verify(r.Method, r.URL.Path, query, h.clock.Now())
+	if err != nil {
+		h.logger.Info("request-denied", lager.Data{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"key-id":      query.Get(KeyIDParam),
+			"remote-addr": r.RemoteAddr,
+			"reason":      err.Error(),
+		})
+		audit.Record(r, audit.Event{
+			Type:      audit.EventAccessDenied,
+			Component: "signed-url",
+			Outcome:   audit.OutcomeDenied,
+			Reason:    err.Error(),
+			Details:   map[string]string{"key_id": query.Get(KeyIDParam)},
+		})
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	h.signed.ServeHTTP(w, r)
+}
@@ -0,0 +1,128 @@
+package signedurl_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"code.cloudfoundry.org/fileserver/handlers/signedurl"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("SignedURL", func() {
+	var (
+		logger    *lagertest.TestLogger
+		fakeClock *fakeclock.FakeClock
+		keys      signedurl.Keys
+		handler   http.Handler
+		recorder  *httptest.ResponseRecorder
+	)
+
+	oldKey := signedurl.Key{ID: "old", Secret: "old-secret-old-secret-old-secret-old"}
+	newKey := signedurl.Key{ID: "new", Secret: "new-secret-new-secret-new-secret-new"}
+
+	request := func(method, path string, query url.Values) *http.Request {
+		u := url.URL{Path: path, RawQuery: query.Encode()}
+		return httptest.NewRequest(method, u.String(), nil)
+	}
+
+	BeforeEach(func() {
+		logger = lagertest.NewTestLogger("test")
+		fakeClock = fakeclock.NewFakeClock(time.Unix(1700000000, 0))
+		keys = signedurl.Keys{newKey, oldKey}
+		recorder = httptest.NewRecorder()
+
+		signed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("signed"))
+		})
+		unsigned := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("unsigned"))
+		})
+		handler = signedurl.New(logger, keys, fakeClock, signed, unsigned)
+	})
+
+	It("passes requests without a signature to the unsigned handler", func() {
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("unsigned"))
+	})
+
+	It("passes requests with a valid signature to the signed handler", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(recorder.Body.String()).To(Equal("signed"))
+	})
+
+	It("accepts signatures made with any configured key", func() {
+		query := signedurl.Sign(oldKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Body.String()).To(Equal("signed"))
+	})
+
+	It("rejects signatures made with an unknown key", func() {
+		unknownKey := signedurl.Key{ID: "unknown", Secret: newKey.Secret}
+		query := signedurl.Sign(unknownKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(logger).To(gbytes.Say("test.signed-url.request-denied"))
+	})
+
+	It("rejects a key id swapped for another configured key", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		query.Set(signedurl.KeyIDParam, oldKey.ID)
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("rejects expired signatures", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		fakeClock.Increment(2 * time.Minute)
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("reason", signedurl.ErrExpired.Error()))
+	})
+
+	It("rejects signatures for another path", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/other", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("rejects signatures for another method", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		handler.ServeHTTP(recorder, request("HEAD", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("rejects a tampered expiry", func() {
+		query := signedurl.Sign(newKey, "GET", "/v1/static/test", fakeClock.Now().Add(time.Minute))
+		query.Set(signedurl.ExpiresParam, "9999999999")
+		handler.ServeHTTP(recorder, request("GET", "/v1/static/test", query))
+		Expect(recorder.Code).To(Equal(http.StatusForbidden))
+		Expect(logger.Logs()[0].Data).To(HaveKeyWithValue("reason", signedurl.ErrInvalidSignature.Error()))
+	})
+
+	Describe("Validate", func() {
+		It("accepts valid keys", func() {
+			Expect(keys.Validate()).To(Succeed())
+		})
+
+		It("rejects duplicate ids", func() {
+			Expect(signedurl.Keys{newKey, newKey}.Validate()).To(MatchError(ContainSubstring("duplicate")))
+		})
+
+		It("rejects missing ids", func() {
+			Expect(signedurl.Keys{{Secret: newKey.Secret}}.Validate()).To(HaveOccurred())
+		})
+
+		It("rejects short secrets", func() {
+			Expect(signedurl.Keys{{ID: "short", Secret: "secret"}}.Validate()).To(MatchError(ContainSubstring("at least")))
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// CacheControlRule describes the Cache-Control header sent for files whose
+// path matches Pattern. Patterns use path.Match syntax; a pattern without a
+// slash is matched against the file name only, otherwise it is matched
+// against the full path below the static directory (e.g. "/buildpacks/*.zip").
+// MaxAge is only sent when it is set, so a max_age of 0 sends max-age=0.
+type CacheControlRule struct {
+	Pattern              string `json:"pattern,omitempty"`
+	MaxAge               *int   `json:"max_age,omitempty"`
+	StaleWhileRevalidate int    `json:"stale_while_revalidate,omitempty"`
+	Immutable            bool   `json:"immutable,omitempty"`
+	NoCache              bool   `json:"no_cache,omitempty"`
+}
+
+// CacheControlConfig is an ordered list of rules, the first matching rule
+// wins. Default applies to files that match no rule; when it is nil no
+// Cache-Control header is sent for them.
+type CacheControlConfig struct {
+	Rules   []CacheControlRule `json:"rules,omitempty"`
+	Default *CacheControlRule  `json:"default,omitempty"`
+}
+
+// Validate checks that every pattern is well formed and that every rule
+// sets at least one directive and none that contradict each other.
+func (c CacheControlConfig) Validate() error {
+	for _, rule := range c.Rules {
+		if rule.Pattern == "" {
+			return errors.New("cache control rule is missing a pattern")
+		}
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid cache control pattern %q: %w", rule.Pattern, err)
+		}
+		if err := rule.validateDirectives(); err != nil {
+			return err
+		}
+	}
+
+	if c.Default != nil {
+		return c.Default.validateDirectives()
+	}
+
+	return nil
+}
+
+// headerFor returns the Cache-Control value for the given cleaned path, or
+// the empty string if no header should be sent.
+func (c CacheControlConfig) headerFor(p string) string {
+	for _, rule := range c.Rules {
+		if rule.matches(p) {
+			return rule.header()
+		}
+	}
+
+	if c.Default != nil {
+		return c.Default.header()
+	}
+
+	return ""
+}
+
+func (r CacheControlRule) matches(p string) bool {
+	subject := p
+	if !strings.Contains(r.Pattern, "/") {
+		subject = path.Base(p)
+	}
+	matched, err := path.Match(r.Pattern, subject)
+	return err == nil && matched
+}
+
+func (r CacheControlRule) validateDirectives() error {
+	if r.MaxAge != nil && *r.MaxAge < 0 || r.StaleWhileRevalidate < 0 {
+		return fmt.Errorf("cache control rule %q has a negative max age", r.Pattern)
+	}
+	if r.NoCache && r.Immutable {
+		return fmt.Errorf("cache control rule %q cannot be both no-cache and immutable", r.Pattern)
+	}
+	if r.header() == "" {
+		// an empty header would also keep the default rule from applying
+		return fmt.Errorf("cache control rule %q sets no directives", r.Pattern)
+	}
+	return nil
+}
+
+func (r CacheControlRule) header() string {
+	var directives []string
+	if r.NoCache {
+		directives = append(directives, "no-cache")
+	}
+	if r.MaxAge != nil {
+		directives = append(directives, fmt.Sprintf("max-age=%d", *r.MaxAge))
+	}
+	if r.StaleWhileRevalidate > 0 {
+		directives = append(directives, fmt.Sprintf("stale-while-revalidate=%d", r.StaleWhileRevalidate))
+	}
+	if r.Immutable {
+		directives = append(directives, "immutable")
+	}
+	return strings.Join(directives, ", ")
+}
@@ -0,0 +1,210 @@
+package static
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DigestEntry is a cached SHA-256 checksum of the file at Path, relative to
+// the static directory.
+type DigestEntry struct {
+	Path       string    `json:"path"`
+	Digest     string    `json:"digest"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// DigestCacheStats counts the entries and lookups of a DigestCache.
+type DigestCacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Purged  uint64 `json:"purged"`
+}
+
+// DigestCache holds the checksums the file server uses as ETags. A cache
+// belongs to the single file server it is passed to with WithDigestCache,
+// which it reads files from when asked to rehash them.
+type DigestCache struct {
+	root     http.FileSystem
+	ignore   IgnorePatterns
+	observer HashObserver
+
+	entries sync.Map
+	size    atomic.Int64
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	purged  atomic.Uint64
+}
+
+// NewDigestCache returns an empty cache.
+func NewDigestCache() *DigestCache {
+	return &DigestCache{}
+}
+
+// attach makes the cache read files from root. Only the first file server
+// a cache is passed to attaches it, so other handlers built from the same
+// options cannot re-point it at a different directory.
+func (c *DigestCache) attach(root http.FileSystem, ignore IgnorePatterns, observer HashObserver) {
+	if c.root != nil {
+		return
+	}
+	c.root = root
+	c.ignore = ignore
+	c.observer = observer
+}
+
+// WithDigestCache keeps checksums in cache so they can be inspected and
+// purged while the server runs.
+func WithDigestCache(cache *DigestCache) Option {
+	return func(f *fileServer) {
+		f.digests = cache
+	}
+}
+
+func (c *DigestCache) load(p string) (string, bool) {
+	cached, ok := c.entries.Load(p)
+	if !ok {
+		c.misses.Add(1)
+		return "", false
+	}
+	c.hits.Add(1)
+	return cached.(DigestEntry).Digest, true
+}
+
+// compute hashes the contents of file and caches the result for p.
+func (c *DigestCache) compute(p string, file io.Reader) (DigestEntry, error) {
+	start := time.Now()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return DigestEntry{}, err
+	}
+	entry := DigestEntry{Path: p, Digest: hex.EncodeToString(h.Sum(nil)), ComputedAt: time.Now()}
+
+	_, loaded := c.entries.Swap(p, entry)
+	if !loaded {
+		c.size.Add(1)
+	}
+	if c.observer != nil {
+		c.observer.ObserveHash(time.Since(start))
+		c.observer.SetShaCacheEntries(int(c.size.Load()))
+	}
+	return entry, nil
+}
+
+// Entries returns the cached checksums of the paths below prefix, sorted by
+// path. An empty prefix returns all of them.
+func (c *DigestCache) Entries(prefix string) []DigestEntry {
+	prefix = cleanPrefix(prefix)
+	entries := []DigestEntry{}
+	c.entries.Range(func(_, v any) bool {
+		entry := v.(DigestEntry)
+		if strings.HasPrefix(entry.Path, prefix) {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+// Purge drops the checksum of p, which is computed again on its next
+// request. It returns how many entries were removed.
+func (c *DigestCache) Purge(p string) int {
+	if !c.delete(path.Clean("/" + p)) {
+		return 0
+	}
+	c.purgedEntries(1)
+	return 1
+}
+
+// PurgePrefix drops the checksums of all paths below prefix and returns how
+// many entries were removed.
+func (c *DigestCache) PurgePrefix(prefix string) int {
+	prefix = cleanPrefix(prefix)
+	removed := 0
+	c.entries.Range(func(k, _ any) bool {
+		if strings.HasPrefix(k.(string), prefix) && c.delete(k.(string)) {
+			removed++
+		}
+		return true
+	})
+	c.purgedEntries(removed)
+	return removed
+}
+
+// cleanPrefix roots a non-empty prefix at the static directory like the
+// paths it is compared with, keeping a trailing slash.
+func cleanPrefix(prefix string) string {
+	if prefix == "" || strings.HasPrefix(prefix, "/") {
+		return prefix
+	}
+	return "/" + prefix
+}
+
+func (c *DigestCache) delete(p string) bool {
+	if _, loaded := c.entries.LoadAndDelete(p); !loaded {
+		return false
+	}
+	c.size.Add(-1)
+	return true
+}
+
+func (c *DigestCache) purgedEntries(removed int) {
+	if removed == 0 {
+		return
+	}
+	c.purged.Add(uint64(removed))
+	if c.observer != nil {
+		c.observer.SetShaCacheEntries(int(c.size.Load()))
+	}
+}
+
+// Rehash computes the checksum of the file at p again and caches it,
+// whether or not it was cached before. Files hidden by the file server's
+// ignore patterns are reported as not existing.
+func (c *DigestCache) Rehash(p string) (DigestEntry, error) {
+	if c.root == nil {
+		return DigestEntry{}, errors.New("digest cache is not used by a file server")
+	}
+	p = path.Clean("/" + p)
+	if c.ignore.matches(p) {
+		return DigestEntry{}, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+
+	file, err := c.root.Open(p)
+	if err != nil {
+		return DigestEntry{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return DigestEntry{}, err
+	}
+	if info.IsDir() {
+		return DigestEntry{}, errors.New("cannot hash a directory")
+	}
+
+	return c.compute(p, file)
+}
+
+// Stats returns the number of entries and how often they were looked up
+// and purged.
+func (c *DigestCache) Stats() DigestCacheStats {
+	return DigestCacheStats{
+		Entries: int(c.size.Load()),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Purged:  c.purged.Load(),
+	}
+}
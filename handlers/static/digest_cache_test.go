@@ -0,0 +1,146 @@
+package static_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("DigestCache", func() {
+	var (
+		servedDirectory string
+		cache           *static.DigestCache
+		observer        *fakeHashObserver
+		handler         http.Handler
+	)
+
+	digestOf := func(contents string) string {
+		sum := sha256.Sum256([]byte(contents))
+		return hex.EncodeToString(sum[:])
+	}
+
+	get := func(name string) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/"+name, nil))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		return recorder.Header().Get("ETag")
+	}
+
+	BeforeEach(func() {
+		servedDirectory = GinkgoT().TempDir()
+		Expect(os.MkdirAll(filepath.Join(servedDirectory, "buildpacks"), 0755)).To(Succeed())
+		for name, contents := range map[string]string{
+			"buildpacks/go.zip":   "go",
+			"buildpacks/ruby.zip": "ruby",
+			"lifecycle.tgz":       "lifecycle",
+		} {
+			Expect(os.WriteFile(filepath.Join(servedDirectory, name), []byte(contents), 0644)).To(Succeed())
+		}
+
+		cache = static.NewDigestCache()
+		observer = &fakeHashObserver{}
+		handler = static.NewFileServer(servedDirectory, static.WithDigestCache(cache), static.WithHashObserver(observer), static.WithIgnorePatterns(static.DefaultIgnorePatterns))
+
+		get("buildpacks/go.zip")
+		get("buildpacks/ruby.zip")
+		get("lifecycle.tgz")
+	})
+
+	It("lists the cached checksums with when they were computed", func() {
+		entries := cache.Entries("")
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[0].Path).To(Equal("/buildpacks/go.zip"))
+		Expect(entries[0].Digest).To(Equal(digestOf("go")))
+		Expect(entries[0].ComputedAt).To(BeTemporally("~", time.Now(), time.Minute))
+
+		Expect(cache.Entries("/buildpacks/")).To(HaveLen(2))
+	})
+
+	It("counts entries and lookups", func() {
+		get("lifecycle.tgz")
+
+		Expect(cache.Stats()).To(Equal(static.DigestCacheStats{Entries: 3, Hits: 1, Misses: 3}))
+	})
+
+	It("purges a single path", func() {
+		Expect(cache.Purge("/lifecycle.tgz")).To(Equal(1))
+		Expect(cache.Purge("/lifecycle.tgz")).To(Equal(0))
+
+		Expect(cache.Stats()).To(Equal(static.DigestCacheStats{Entries: 2, Misses: 3, Purged: 1}))
+		observer.Lock()
+		Expect(observer.entries).To(Equal(2))
+		observer.Unlock()
+	})
+
+	It("purges paths given without a leading slash", func() {
+		Expect(cache.Purge("buildpacks/go.zip")).To(Equal(1))
+		Expect(cache.PurgePrefix("buildpacks/")).To(Equal(1))
+		Expect(cache.Entries("")).To(ConsistOf(HaveField("Path", "/lifecycle.tgz")))
+	})
+
+	It("purges the paths below a prefix", func() {
+		Expect(cache.PurgePrefix("/buildpacks/")).To(Equal(2))
+		Expect(cache.Entries("")).To(ConsistOf(HaveField("Path", "/lifecycle.tgz")))
+
+		observer.Lock()
+		Expect(observer.entries).To(Equal(1))
+		observer.Unlock()
+	})
+
+	It("computes a purged checksum again on the next request", func() {
+		Expect(os.WriteFile(filepath.Join(servedDirectory, "lifecycle.tgz"), []byte("changed"), 0644)).To(Succeed())
+		Expect(get("lifecycle.tgz")).To(Equal(`"` + digestOf("lifecycle") + `"`))
+
+		cache.Purge("/lifecycle.tgz")
+		Expect(get("lifecycle.tgz")).To(Equal(`"` + digestOf("changed") + `"`))
+	})
+
+	Describe("Rehash", func() {
+		It("replaces the cached checksum", func() {
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "lifecycle.tgz"), []byte("changed"), 0644)).To(Succeed())
+
+			entry, err := cache.Rehash("/lifecycle.tgz")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.Digest).To(Equal(digestOf("changed")))
+			Expect(get("lifecycle.tgz")).To(Equal(`"` + digestOf("changed") + `"`))
+		})
+
+		It("stays within the static directory", func() {
+			_, err := cache.Rehash("/../" + filepath.Base(servedDirectory) + "/lifecycle.tgz")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("keeps reading from the directory of the first file server it is passed to", func() {
+			otherDirectory := GinkgoT().TempDir()
+			Expect(os.WriteFile(filepath.Join(otherDirectory, "lifecycle.tgz"), []byte("other"), 0644)).To(Succeed())
+			static.NewFileServer(otherDirectory, static.WithDigestCache(cache))
+
+			entry, err := cache.Rehash("/lifecycle.tgz")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entry.Digest).To(Equal(digestOf("lifecycle")))
+		})
+
+		It("does not hash ignored files", func() {
+			Expect(os.MkdirAll(filepath.Join(servedDirectory, ".git"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, ".git", "config"), []byte("secret"), 0644)).To(Succeed())
+
+			_, err := cache.Rehash("/.git/config")
+			Expect(err).To(MatchError(fs.ErrNotExist))
+			Expect(cache.Entries("")).To(HaveLen(3))
+		})
+
+		It("rejects directories", func() {
+			_, err := cache.Rehash("/buildpacks")
+			Expect(err).To(MatchError("cannot hash a directory"))
+		})
+	})
+})
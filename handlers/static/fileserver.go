@@ -5,32 +5,91 @@
 package static
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"sync"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/audit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type fileServer struct {
-	root     http.FileSystem
-	shaCache sync.Map
+	dir            string
+	root           http.FileSystem
+	digests        *DigestCache
+	cacheControl   CacheControlConfig
+	ignorePatterns IgnorePatterns
+	metrics        *metrics
+	hashObserver   HashObserver
+	accessLogger   AccessLogger
+	assetObserver  AssetObserver
+	tracer         trace.Tracer
+	middleware     []func(http.Handler) http.Handler
+}
+
+// HashObserver is told how long computing each checksum took and how many
+// checksums are cached.
+type HashObserver interface {
+	ObserveHash(duration time.Duration)
+	SetShaCacheEntries(entries int)
+}
+
+// WithTracer records spans for opening the file, computing its checksum and
+// transferring it.
+func WithTracer(tracer trace.Tracer) Option {
+	return func(f *fileServer) {
+		f.tracer = tracer
+	}
 }
 
-func NewFileServer(dir string) http.Handler {
-	return &fileServer{
-		root: http.Dir(dir),
+// WithHashObserver reports checksum computations to observer.
+func WithHashObserver(observer HashObserver) Option {
+	return func(f *fileServer) {
+		f.hashObserver = observer
 	}
 }
 
+// Option configures optional behaviour of the file server.
+type Option func(*fileServer)
+
+// WithCacheControl sets the Cache-Control header on successful and not
+// modified responses according to the given rules.
+func WithCacheControl(config CacheControlConfig) Option {
+	return func(f *fileServer) {
+		f.cacheControl = config
+	}
+}
+
+func NewFileServer(dir string, opts ...Option) http.Handler {
+	return newFileServer(dir, opts...)
+}
+
+func newFileServer(dir string, opts ...Option) *fileServer {
+	f := &fileServer{
+		dir:    dir,
+		root:   http.Dir(dir),
+		tracer: noop.NewTracerProvider().Tracer(""),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.digests == nil {
+		f.digests = NewDigestCache()
+	}
+	f.digests.attach(f.root, f.ignorePatterns, f.hashObserver)
+	return f
+}
+
 func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	upath := r.URL.Path
 	if containsDotDot(upath) {
+		audit.Record(r, audit.Event{Type: audit.EventPathTraversal, Component: "static", Outcome: audit.OutcomeDenied})
 		http.Error(w, "invalid URL path", http.StatusBadRequest)
 		return
 	}
@@ -41,31 +100,45 @@ func (f *fileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	tgzPath := path.Clean(upath)
 
-	file, fileStats := f.validateFile(tgzPath, w)
+	if f.ignorePatterns.matches(tgzPath) {
+		http.Error(w, fmt.Sprintf("File not found: %s", filepath.Base(tgzPath)), http.StatusNotFound)
+		return
+	}
+
+	ctx := r.Context()
+	_, openSpan := f.tracer.Start(ctx, "open", trace.WithAttributes(attribute.String("file.path", tgzPath)))
+	file, fileStats := f.validateFile(tgzPath, w, r)
+	openSpan.End()
 	if file == nil {
 		return
 	}
 	defer file.Close()
 
-	cached, ok := f.shaCache.Load(tgzPath)
-	sha256sum, valid := cached.(string)
-	if !ok || !valid {
-		h := sha256.New()
-		if _, err := io.Copy(h, file); err != nil {
+	sha256sum, ok := f.digests.load(tgzPath)
+	f.metrics.shaCache(ok)
+	if !ok {
+		_, digestSpan := f.tracer.Start(ctx, "digest", trace.WithAttributes(attribute.Int64("file.size", fileStats.Size())))
+		entry, err := f.digests.compute(tgzPath, file)
+		digestSpan.End()
+		if err != nil {
 			http.Error(w, "Error calculating checksum of file", http.StatusInternalServerError)
 			return
 		}
-		sha256sum = hex.EncodeToString(h.Sum(nil)[:])
-		f.shaCache.Store(tgzPath, sha256sum)
+		sha256sum = entry.Digest
 	}
 	w.Header().Set("ETag", fmt.Sprintf(`"%s"`, sha256sum))
+	if cacheControl := f.cacheControl.headerFor(tgzPath); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
 
+	_, transferSpan := f.tracer.Start(ctx, "transfer")
+	defer transferSpan.End()
 	http.ServeContent(w, r, fileStats.Name(), fileStats.ModTime(), file)
 }
 
 // validateFile checks that a file can be found and is not a directory. It
 // responds with an HTTP error and nil file
-func (f *fileServer) validateFile(p string, w http.ResponseWriter) (ret http.File, stat os.FileInfo) {
+func (f *fileServer) validateFile(p string, w http.ResponseWriter, r *http.Request) (ret http.File, stat os.FileInfo) {
 	file, err := f.root.Open(p)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("File not found: %s", filepath.Base(p)), http.StatusNotFound)
@@ -85,6 +158,7 @@ func (f *fileServer) validateFile(p string, w http.ResponseWriter) (ret http.Fil
 	}
 
 	if d.IsDir() {
+		audit.Record(r, audit.Event{Type: audit.EventDirectoryAccess, Component: "static", Outcome: audit.OutcomeDenied})
 		http.Error(w, "Unauthorized to list the directory", http.StatusUnauthorized)
 		return nil, nil
 	}
@@ -9,8 +9,10 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
+	"code.cloudfoundry.org/fileserver/handlers/audit"
 	"code.cloudfoundry.org/fileserver/handlers/static"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -48,6 +50,33 @@ var _ = Describe("FileServer", func() {
 		os.RemoveAll(servedDirectory)
 	})
 
+	Context("when a hash observer is configured", func() {
+		var observer *fakeHashObserver
+
+		BeforeEach(func() {
+			observer = &fakeHashObserver{}
+			fileServer.Close()
+			fileServer = httptest.NewServer(static.NewFileServer(servedDirectory, static.WithHashObserver(observer)))
+		})
+
+		get := func(name string) {
+			resp, err := http.Get(fmt.Sprintf("%s/%s", fileServer.URL, name))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+
+		It("reports each checksum computed and the cache size", func() {
+			get("test")
+			get("test")
+			get("test2..")
+
+			observer.Lock()
+			defer observer.Unlock()
+			Expect(observer.hashes).To(Equal(2))
+			Expect(observer.entries).To(Equal(2))
+		})
+	})
+
 	Context("when the file exists", func() {
 		It("returns a 200 OK, the file content and its ETag", func() {
 			resp, err := http.Get(fmt.Sprintf("%s/test", fileServer.URL))
@@ -131,6 +160,311 @@ var _ = Describe("FileServer", func() {
 		})
 	})
 
+	Context("when cache control rules are configured", func() {
+		var cacheControl static.CacheControlConfig
+
+		BeforeEach(func() {
+			os.WriteFile(filepath.Join(servedDirectory, "testdir", "lifecycle.tgz"), []byte("lifecycle"), os.ModePerm)
+			os.WriteFile(filepath.Join(servedDirectory, "testdir", "index.json"), []byte("{}"), os.ModePerm)
+			cacheControl = static.CacheControlConfig{
+				Rules: []static.CacheControlRule{
+					{Pattern: "*.tgz", MaxAge: maxAge(31536000), Immutable: true},
+					{Pattern: "/test", MaxAge: maxAge(60), StaleWhileRevalidate: 30},
+					{Pattern: "*.json", MaxAge: maxAge(0), StaleWhileRevalidate: 30},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			fileServer.Close()
+			fileServer = httptest.NewServer(static.NewFileServer(servedDirectory, static.WithCacheControl(cacheControl)))
+		})
+
+		It("sets the Cache-Control header of the first matching rule on a 200 OK", func() {
+			resp, err := http.Get(fmt.Sprintf("%s/testdir/lifecycle.tgz", fileServer.URL))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("max-age=31536000, immutable"))
+		})
+
+		It("sets the Cache-Control header on a 304 Not Modified", func() {
+			req, err := http.NewRequest("GET", fmt.Sprintf("%s/test", fileServer.URL), nil)
+			Expect(err).NotTo(HaveOccurred())
+			req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, expectedShaTest))
+
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("max-age=60, stale-while-revalidate=30"))
+		})
+
+		It("sends a max age of 0 that is set explicitly", func() {
+			resp, err := http.Get(fmt.Sprintf("%s/testdir/index.json", fileServer.URL))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Cache-Control")).To(Equal("max-age=0, stale-while-revalidate=30"))
+		})
+
+		It("does not set the Cache-Control header when no rule matches", func() {
+			resp, err := http.Get(fmt.Sprintf("%s/test2..", fileServer.URL))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header).NotTo(HaveKey("Cache-Control"))
+		})
+
+		It("does not set the Cache-Control header on errors", func() {
+			resp, err := http.Get(fmt.Sprintf("%s/does-not-exist.tgz", fileServer.URL))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(resp.Header).NotTo(HaveKey("Cache-Control"))
+		})
+
+		Context("when a default rule is configured", func() {
+			BeforeEach(func() {
+				cacheControl.Default = &static.CacheControlRule{NoCache: true}
+			})
+
+			It("uses the default rule for files that match no rule", func() {
+				resp, err := http.Get(fmt.Sprintf("%s/test2..", fileServer.URL))
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Cache-Control")).To(Equal("no-cache"))
+			})
+
+			It("uses the default rule on a 304 Not Modified", func() {
+				req, err := http.NewRequest("GET", fmt.Sprintf("%s/test2..", fileServer.URL), nil)
+				Expect(err).NotTo(HaveOccurred())
+				req.Header.Set("If-None-Match", fmt.Sprintf(`"%s"`, expectedShaTest2))
+
+				resp, err := http.DefaultClient.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				defer resp.Body.Close()
+
+				Expect(resp.StatusCode).To(Equal(http.StatusNotModified))
+				Expect(resp.Header.Get("Cache-Control")).To(Equal("no-cache"))
+			})
+		})
+	})
+
+	Context("when ignore patterns are configured", func() {
+		get := func(p string) *http.Response {
+			resp, err := http.Get(fmt.Sprintf("%s/%s", fileServer.URL, p))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			return resp
+		}
+
+		BeforeEach(func() {
+			Expect(os.Mkdir(filepath.Join(servedDirectory, ".git"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, ".git", "config"), []byte("git"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, ".env"), []byte("env"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "testdir", ".lifecycle.tgz.swp"), []byte("swap"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "testdir", "lifecycle.tgz.tmp"), []byte("partial"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "testdir", "lifecycle.tgz.meta"), []byte("meta"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "testdir", "lifecycle.tgz"), []byte("lifecycle"), os.ModePerm)).To(Succeed())
+
+			patterns := append(static.IgnorePatterns{"*.meta"}, static.DefaultIgnorePatterns...)
+			fileServer.Close()
+			fileServer = httptest.NewServer(static.NewFileServer(servedDirectory, static.WithIgnorePatterns(patterns)))
+		})
+
+		It("returns 404 for dotfiles and files in dot directories", func() {
+			Expect(get(".env").StatusCode).To(Equal(http.StatusNotFound))
+			Expect(get(".git/config").StatusCode).To(Equal(http.StatusNotFound))
+			Expect(get("testdir/.lifecycle.tgz.swp").StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("returns 404 for temporary and sidecar files", func() {
+			Expect(get("testdir/lifecycle.tgz.tmp").StatusCode).To(Equal(http.StatusNotFound))
+			Expect(get("testdir/lifecycle.tgz.meta").StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("does not compute an ETag for ignored files", func() {
+			Expect(get(".env").Header).NotTo(HaveKey("Etag"))
+		})
+
+		It("still serves other files", func() {
+			Expect(get("testdir/lifecycle.tgz").StatusCode).To(Equal(http.StatusOK))
+			Expect(get("test2..").StatusCode).To(Equal(http.StatusOK))
+		})
+
+		Context("when a pattern contains a slash", func() {
+			BeforeEach(func() {
+				fileServer.Close()
+				fileServer = httptest.NewServer(static.NewFileServer(servedDirectory, static.WithIgnorePatterns(static.IgnorePatterns{"/testdir"})))
+			})
+
+			It("hides the matching path and everything below it", func() {
+				Expect(get("testdir/lifecycle.tgz").StatusCode).To(Equal(http.StatusNotFound))
+				Expect(get("test").StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	Context("when a symlink policy is configured", func() {
+		var (
+			siblingDirectory string
+			policy           static.SymlinkPolicy
+		)
+
+		get := func(p string) int {
+			resp, err := http.Get(fmt.Sprintf("%s/%s", fileServer.URL, p))
+			Expect(err).NotTo(HaveOccurred())
+			defer resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		BeforeEach(func() {
+			// shares the served directory's name as a prefix to catch naive prefix checks
+			siblingDirectory = servedDirectory + "-sibling"
+			Expect(os.Mkdir(siblingDirectory, os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(siblingDirectory, "secret"), []byte("secret"), os.ModePerm)).To(Succeed())
+
+			Expect(os.Symlink("/etc", filepath.Join(servedDirectory, "etc"))).To(Succeed())
+			Expect(os.Symlink("/etc/passwd", filepath.Join(servedDirectory, "passwd"))).To(Succeed())
+			Expect(os.Symlink(siblingDirectory, filepath.Join(servedDirectory, "sibling"))).To(Succeed())
+			Expect(os.Symlink(filepath.Join("..", filepath.Base(siblingDirectory), "secret"), filepath.Join(servedDirectory, "relative-secret"))).To(Succeed())
+			Expect(os.Symlink("test", filepath.Join(servedDirectory, "link-to-test"))).To(Succeed())
+			Expect(os.Symlink("testdir", filepath.Join(servedDirectory, "link-to-testdir"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(servedDirectory, "testdir", "nested"), []byte("nested"), os.ModePerm)).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			fileServer.Close()
+			fileServer = httptest.NewServer(static.NewFileServer(servedDirectory, static.WithSymlinkPolicy(policy)))
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(siblingDirectory)
+		})
+
+		Context("when links are followed", func() {
+			BeforeEach(func() {
+				policy = static.SymlinkFollow
+			})
+
+			It("serves files behind any link", func() {
+				Expect(get("passwd")).To(Equal(http.StatusOK))
+				Expect(get("etc/passwd")).To(Equal(http.StatusOK))
+				Expect(get("sibling/secret")).To(Equal(http.StatusOK))
+				Expect(get("link-to-test")).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("when links are only followed within the root", func() {
+			BeforeEach(func() {
+				policy = static.SymlinkFollowWithinRoot
+			})
+
+			It("serves files behind links that stay inside the static directory", func() {
+				Expect(get("test")).To(Equal(http.StatusOK))
+				Expect(get("link-to-test")).To(Equal(http.StatusOK))
+				Expect(get("link-to-testdir/nested")).To(Equal(http.StatusOK))
+			})
+
+			It("refuses links pointing at /etc", func() {
+				Expect(get("passwd")).To(Equal(http.StatusNotFound))
+				Expect(get("etc/passwd")).To(Equal(http.StatusNotFound))
+			})
+
+			It("refuses links pointing at a sibling directory", func() {
+				Expect(get("sibling/secret")).To(Equal(http.StatusNotFound))
+				Expect(get("relative-secret")).To(Equal(http.StatusNotFound))
+			})
+
+			Context("when the static directory itself is reached through a link", func() {
+				var linkedDirectory string
+
+				BeforeEach(func() {
+					linkedDirectory = servedDirectory + "-link"
+					Expect(os.Symlink(servedDirectory, linkedDirectory)).To(Succeed())
+				})
+
+				JustBeforeEach(func() {
+					fileServer.Close()
+					fileServer = httptest.NewServer(static.NewFileServer(linkedDirectory, static.WithSymlinkPolicy(policy)))
+				})
+
+				AfterEach(func() {
+					os.Remove(linkedDirectory)
+				})
+
+				It("still serves files inside it", func() {
+					Expect(get("link-to-test")).To(Equal(http.StatusOK))
+					Expect(get("passwd")).To(Equal(http.StatusNotFound))
+				})
+			})
+		})
+
+		Context("when links are denied", func() {
+			BeforeEach(func() {
+				policy = static.SymlinkDeny
+			})
+
+			It("serves regular files", func() {
+				Expect(get("test")).To(Equal(http.StatusOK))
+				Expect(get("testdir/nested")).To(Equal(http.StatusOK))
+			})
+
+			It("refuses every path passing through a link", func() {
+				Expect(get("passwd")).To(Equal(http.StatusNotFound))
+				Expect(get("etc/passwd")).To(Equal(http.StatusNotFound))
+				Expect(get("sibling/secret")).To(Equal(http.StatusNotFound))
+				Expect(get("link-to-test")).To(Equal(http.StatusNotFound))
+				Expect(get("link-to-testdir/nested")).To(Equal(http.StatusNotFound))
+			})
+		})
+	})
+
+	Context("when an audit recorder is attached", func() {
+		var recorder *fakeAuditRecorder
+
+		BeforeEach(func() {
+			recorder = &fakeAuditRecorder{}
+			fileServer.Close()
+			fileServer = httptest.NewServer(audit.Handler(recorder, static.NewFileServer(servedDirectory)))
+		})
+
+		get := func(name string) {
+			resp, err := http.Get(fmt.Sprintf("%s/%s", fileServer.URL, name))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+		}
+
+		It("records path traversal attempts", func() {
+			get("../protected-file")
+			Expect(recorder.recorded()).To(ConsistOf(SatisfyAll(
+				HaveField("Type", audit.EventPathTraversal),
+				HaveField("URI", "/../protected-file"),
+			)))
+		})
+
+		It("records requests for directories", func() {
+			get("testdir")
+			Expect(recorder.recorded()).To(ConsistOf(SatisfyAll(
+				HaveField("Type", audit.EventDirectoryAccess),
+				HaveField("URI", "/testdir"),
+			)))
+		})
+
+		It("records nothing for files that are served", func() {
+			get("test")
+			Expect(recorder.recorded()).To(BeEmpty())
+		})
+	})
+
 	It("returns 400 on filepaths with dot dot", func() {
 		resp, err := http.Get(fmt.Sprintf("%s/../protected-file", fileServer.URL))
 		Expect(err).NotTo(HaveOccurred())
@@ -156,3 +490,42 @@ var _ = Describe("FileServer", func() {
 	})
 
 })
+
+type fakeAuditRecorder struct {
+	sync.Mutex
+	events []audit.Event
+}
+
+func (r *fakeAuditRecorder) Record(event audit.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *fakeAuditRecorder) recorded() []audit.Event {
+	r.Lock()
+	defer r.Unlock()
+	return r.events
+}
+
+type fakeHashObserver struct {
+	sync.Mutex
+	hashes  int
+	entries int
+}
+
+func (o *fakeHashObserver) ObserveHash(time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+	o.hashes++
+}
+
+func (o *fakeHashObserver) SetShaCacheEntries(entries int) {
+	o.Lock()
+	defer o.Unlock()
+	o.entries = entries
+}
+
+func maxAge(seconds int) *int {
+	return &seconds
+}
@@ -0,0 +1,60 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// DefaultIgnorePatterns hide dotfiles and directories such as .git, editor
+// backup and swap files, and files that are still being written.
+var DefaultIgnorePatterns = IgnorePatterns{".*", "*~", "*.swp", "*.tmp"}
+
+// IgnorePatterns are path.Match patterns for files that are never served. A
+// pattern without a slash is matched against every component of the path, so
+// ".*" also hides everything below a dot directory; otherwise it is matched
+// against the full path and each of its parent directories
+// (e.g. "/incoming/*").
+type IgnorePatterns []string
+
+// Validate checks that every pattern is well formed.
+func (p IgnorePatterns) Validate() error {
+	for _, pattern := range p {
+		if pattern == "" {
+			return errors.New("ignore pattern must not be empty")
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// WithIgnorePatterns responds with 404 Not Found for files matching any of
+// the patterns, without reading or hashing them.
+func WithIgnorePatterns(patterns IgnorePatterns) Option {
+	return func(f *fileServer) {
+		f.ignorePatterns = patterns
+	}
+}
+
+// matches reports whether the cleaned path p is hidden by any pattern.
+func (p IgnorePatterns) matches(cleanPath string) bool {
+	components := strings.Split(strings.TrimPrefix(cleanPath, "/"), "/")
+	for _, pattern := range p {
+		anchored := strings.Contains(pattern, "/")
+		prefix := ""
+		for _, component := range components {
+			prefix += "/" + component
+			subject := component
+			if anchored {
+				subject = prefix
+			}
+			if matched, err := path.Match(pattern, subject); err == nil && matched {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -1,34 +1,96 @@
 package static
 
 import (
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"code.cloudfoundry.org/lager/v3"
 )
 
 type loggingHandler struct {
 	originalHandler http.Handler
-	logger          lager.Logger
+	accessLogger    AccessLogger
+	metrics         *metrics
+	pathPrefix      string
+	assetObserver   AssetObserver
+}
+
+// AccessLogger records a response. data holds the fields of the access log
+// entry; start is when the request was received.
+type AccessLogger interface {
+	LogResponse(req *http.Request, start time.Time, data lager.Data)
+}
+
+// WithAccessLogger records responses through accessLogger instead of the
+// "static-file" session of the handler's logger.
+func WithAccessLogger(accessLogger AccessLogger) Option {
+	return func(f *fileServer) {
+		f.accessLogger = accessLogger
+	}
+}
+
+// AssetObserver is told about every response that served a file or found
+// the client's copy current. path is relative to the static directory and
+// client is the address returned by ClientIP.
+type AssetObserver interface {
+	ObserveAsset(path string, status, size int, client string)
+}
+
+// WithAssetObserver reports file downloads to observer.
+func WithAssetObserver(observer AssetObserver) Option {
+	return func(f *fileServer) {
+		f.assetObserver = observer
+	}
+}
+
+func accessLogOrDefault(accessLogger AccessLogger, logger lager.Logger) AccessLogger {
+	if accessLogger != nil {
+		return accessLogger
+	}
+	return lagerAccessLogger{logger: logger}
+}
+
+type lagerAccessLogger struct {
+	logger lager.Logger
+}
+
+func (l lagerAccessLogger) LogResponse(_ *http.Request, _ time.Time, data lager.Data) {
+	l.logger.Session("static-file").Info("response", data)
 }
 
 type responseLogger struct {
-	w      http.ResponseWriter
-	status int
-	size   int
+	w         http.ResponseWriter
+	status    int
+	size      int
+	firstByte time.Time
+	writeErr  error
 }
 
 func (l *responseLogger) Write(b []byte) (int, error) {
 	if l.status == 0 {
 		// The status will be StatusOK if WriteHeader has not been called yet
 		l.status = http.StatusOK
+		l.firstByte = time.Now()
 	}
 	size, err := l.w.Write(b)
 	l.size += size
+	if err != nil && l.writeErr == nil {
+		l.writeErr = err
+	}
 	return size, err
 }
 
 func (l *responseLogger) WriteHeader(s int) {
 	l.w.WriteHeader(s)
+	if l.status == 0 {
+		l.firstByte = time.Now()
+	}
 	l.status = s
 }
 
@@ -36,15 +98,122 @@ func (l *responseLogger) Header() http.Header {
 	return l.w.Header()
 }
 
+type logEntryKey struct{}
+
+// logEntry collects what handlers learn about a request for its access log
+// entry. The logging handler attaches it before calling the handlers it
+// wraps, so it also sees what they add to the contexts they pass on.
+type logEntry struct {
+	mu       sync.Mutex
+	data     lager.Data
+	clientIP string
+}
+
+// withLogEntry returns ctx with a log entry attached, reusing one that is
+// already there.
+func withLogEntry(ctx context.Context) (context.Context, *logEntry) {
+	if entry, ok := ctx.Value(logEntryKey{}).(*logEntry); ok {
+		return ctx, entry
+	}
+	entry := &logEntry{data: lager.Data{}}
+	return context.WithValue(ctx, logEntryKey{}, entry), entry
+}
+
+// WithLogData returns a copy of ctx carrying data that is added to the
+// access log entry of the request, e.g. by middleware authenticating it.
+func WithLogData(ctx context.Context, data lager.Data) context.Context {
+	ctx, entry := withLogEntry(ctx)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	for k, v := range data {
+		entry.data[k] = v
+	}
+	return ctx
+}
+
+// WithClientIP returns a copy of ctx carrying the address of the client a
+// request was made for, e.g. as resolved from X-Forwarded-For by a trusted
+// proxy. It is logged as "client-ip".
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	ctx, entry := withLogEntry(ctx)
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.clientIP = ip
+	return ctx
+}
+
+// ClientIP returns the address attached with WithClientIP, or else the
+// host of the request's remote address.
+func ClientIP(req *http.Request) string {
+	if entry, ok := req.Context().Value(logEntryKey{}).(*logEntry); ok {
+		if _, ip := entry.snapshot(); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func (e *logEntry) snapshot() (lager.Data, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	data := make(lager.Data, len(e.data))
+	for k, v := range e.data {
+		data[k] = v
+	}
+	return data, e.clientIP
+}
+
 func (h loggingHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	start := time.Now()
+	ctx, entry := withLogEntry(req.Context())
 	resLogger := &responseLogger{w: w}
-	h.originalHandler.ServeHTTP(resLogger, req)
-
-	requestLogger := h.logger.Session("static-file")
-	requestLogger.Info("response", lager.Data{
-		"status": resLogger.status,
-		"size":   resLogger.size,
-		"method": req.Method,
-		"uri":    req.URL.RequestURI(),
-	})
+	h.originalHandler.ServeHTTP(resLogger, req.WithContext(ctx))
+	duration := time.Since(start)
+	h.metrics.response(resLogger.status, resLogger.size, duration)
+	if h.assetObserver != nil {
+		switch resLogger.status {
+		case http.StatusOK, http.StatusPartialContent, http.StatusNotModified:
+			assetPath := path.Clean("/" + strings.TrimPrefix(req.URL.Path, h.pathPrefix))
+			h.assetObserver.ObserveAsset(assetPath, resLogger.status, resLogger.size, ClientIP(req.WithContext(ctx)))
+		}
+	}
+
+	data := lager.Data{
+		"status":              resLogger.status,
+		"size":                resLogger.size,
+		"method":              req.Method,
+		"uri":                 req.URL.RequestURI(),
+		"duration":            duration,
+		"remote-addr":         req.RemoteAddr,
+		"user-agent":          req.UserAgent(),
+		"protocol":            req.Proto,
+		"client-disconnected": resLogger.writeErr != nil || req.Context().Err() != nil,
+	}
+	if !resLogger.firstByte.IsZero() {
+		data["time-to-first-byte"] = resLogger.firstByte.Sub(start)
+	}
+	if req.TLS != nil {
+		data["tls-version"] = tls.VersionName(req.TLS.Version)
+		data["tls-cipher"] = tls.CipherSuiteName(req.TLS.CipherSuite)
+	}
+	loggedData, clientIP := entry.snapshot()
+	if clientIP != "" {
+		data["client-ip"] = clientIP
+	}
+	if byteRange := req.Header.Get("Range"); byteRange != "" {
+		data["range"] = byteRange
+	}
+	if req.Header.Get("If-None-Match") != "" {
+		// whether the client's cached copy was still current
+		data["etag-match"] = resLogger.status == http.StatusNotModified
+	}
+	for k, v := range loggedData {
+		data[k] = v
+	}
+
+	h.accessLogger.LogResponse(req, start, data)
 }
@@ -0,0 +1,353 @@
+package static_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/diego-logging-client/testhelpers"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/lager/v3"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("LoggingHandler", func() {
+	var (
+		servedDirectory string
+		logger          *lagertest.TestLogger
+		handler         http.Handler
+	)
+
+	BeforeEach(func() {
+		var err error
+		servedDirectory, err = os.MkdirTemp("", "fileserver-test")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), os.ModePerm)).To(Succeed())
+
+		logger = lagertest.NewTestLogger("test")
+		handler = static.New(servedDirectory, "/v1/static/", logger)
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(servedDirectory)).To(Succeed())
+	})
+
+	responseLog := func() lager.LogFormat {
+		logs := logger.Logs()
+		Expect(logs).To(HaveLen(1))
+		Expect(logs[0].Message).To(Equal("test.static-file.response"))
+		return logs[0]
+	}
+
+	It("logs the status, size, method and uri of each response", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusOK)))
+		Expect(data).To(HaveKeyWithValue("size", BeNumerically("==", 5)))
+		Expect(data).To(HaveKeyWithValue("method", "GET"))
+		Expect(data).To(HaveKeyWithValue("uri", "/v1/static/test"))
+	})
+
+	It("logs how long the response took and when its first byte was written", func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("duration", BeNumerically(">", 0)))
+		Expect(data).To(HaveKeyWithValue("time-to-first-byte", BeNumerically(">", 0)))
+		Expect(data["time-to-first-byte"]).To(BeNumerically("<=", data["duration"]))
+	})
+
+	It("logs the client's address, user agent and protocol", func() {
+		request := httptest.NewRequest("GET", "/v1/static/test", nil)
+		request.RemoteAddr = "10.0.0.1:51234"
+		request.Header.Set("User-Agent", "rep/1.0")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("remote-addr", "10.0.0.1:51234"))
+		Expect(data).To(HaveKeyWithValue("user-agent", "rep/1.0"))
+		Expect(data).To(HaveKeyWithValue("protocol", "HTTP/1.1"))
+		Expect(data).To(HaveKeyWithValue("client-disconnected", false))
+		Expect(data).NotTo(HaveKey("tls-version"))
+		Expect(data).NotTo(HaveKey("range"))
+		Expect(data).NotTo(HaveKey("etag-match"))
+	})
+
+	It("logs the TLS version and cipher suite", func() {
+		request := httptest.NewRequest("GET", "/v1/static/test", nil)
+		request.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256}
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("tls-version", "TLS 1.3"))
+		Expect(data).To(HaveKeyWithValue("tls-cipher", "TLS_AES_128_GCM_SHA256"))
+	})
+
+	It("logs the requested range", func() {
+		request := httptest.NewRequest("GET", "/v1/static/test", nil)
+		request.Header.Set("Range", "bytes=1-2")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusPartialContent)))
+		Expect(data).To(HaveKeyWithValue("range", "bytes=1-2"))
+	})
+
+	Context("when the client sends If-None-Match", func() {
+		var etag string
+
+		BeforeEach(func() {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/v1/static/test", nil))
+			etag = recorder.Header().Get("ETag")
+			Expect(etag).NotTo(BeEmpty())
+		})
+
+		lastLog := func() lager.LogFormat {
+			logs := logger.Logs()
+			Expect(logs).To(HaveLen(2))
+			return logs[1]
+		}
+
+		It("logs a match when the ETag is current", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			request.Header.Set("If-None-Match", etag)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(lastLog().Data).To(HaveKeyWithValue("etag-match", true))
+		})
+
+		It("logs a mismatch when the ETag is stale", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			request.Header.Set("If-None-Match", `"stale"`)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(lastLog().Data).To(HaveKeyWithValue("etag-match", false))
+		})
+	})
+
+	It("logs clients that went away before the response was complete", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil).WithContext(ctx))
+
+		Expect(responseLog().Data).To(HaveKeyWithValue("client-disconnected", true))
+	})
+
+	It("logs writes that fail", func() {
+		handler.ServeHTTP(&failingResponseWriter{ResponseRecorder: httptest.NewRecorder()}, httptest.NewRequest("GET", "/v1/static/test", nil))
+
+		Expect(responseLog().Data).To(HaveKeyWithValue("client-disconnected", true))
+	})
+
+	Context("when an access logger is configured", func() {
+		var accessLogger *fakeAccessLogger
+
+		BeforeEach(func() {
+			accessLogger = &fakeAccessLogger{}
+			handler = static.New(servedDirectory, "/v1/static/", logger, static.WithAccessLogger(accessLogger))
+		})
+
+		It("records responses through it instead of the logger", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(logger.Logs()).To(BeEmpty())
+			Expect(accessLogger.requests).To(ConsistOf(request))
+			Expect(accessLogger.data).To(ConsistOf(HaveKeyWithValue("status", http.StatusOK)))
+		})
+	})
+
+	Context("when an asset observer is configured", func() {
+		var assetObserver *fakeAssetObserver
+
+		BeforeEach(func() {
+			assetObserver = &fakeAssetObserver{}
+			handler = static.New(servedDirectory, "/v1/static/", logger, static.WithAssetObserver(assetObserver))
+		})
+
+		It("reports downloads of files by their path in the static directory", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			request.RemoteAddr = "10.0.0.1:4242"
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			Expect(assetObserver.observations).To(ConsistOf(assetObservation{path: "/test", status: http.StatusOK, size: 5, client: "10.0.0.1"}))
+		})
+
+		It("reports and logs the client address attached to the request", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			request.RemoteAddr = "172.16.0.1:4242"
+			handler.ServeHTTP(httptest.NewRecorder(), request.WithContext(static.WithClientIP(request.Context(), "10.0.5.5")))
+
+			Expect(assetObserver.observations).To(ConsistOf(assetObservation{path: "/test", status: http.StatusOK, size: 5, client: "10.0.5.5"}))
+			Expect(responseLog().Data).To(HaveKeyWithValue("client-ip", "10.0.5.5"))
+		})
+
+		It("ignores responses that did not serve a file", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/missing", nil))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/", nil))
+
+			Expect(assetObserver.observations).To(BeEmpty())
+		})
+	})
+
+	It("includes data attached to the request context", func() {
+		request := httptest.NewRequest("GET", "/v1/static/test", nil)
+		ctx := static.WithLogData(request.Context(), lager.Data{"subject": "cc-uploader"})
+		ctx = static.WithLogData(ctx, lager.Data{"request-id": "some-id"})
+		handler.ServeHTTP(httptest.NewRecorder(), request.WithContext(ctx))
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("subject", "cc-uploader"))
+		Expect(data).To(HaveKeyWithValue("request-id", "some-id"))
+	})
+
+	Context("when middleware is configured", func() {
+		var metronClient *testhelpers.FakeIngressClient
+
+		BeforeEach(func() {
+			metronClient = new(testhelpers.FakeIngressClient)
+			handler = static.New(servedDirectory, "/v1/static/", logger,
+				static.WithMetronClient(metronClient),
+				static.WithMiddleware(func(files http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						if r.Header.Get("Authorization") == "" {
+							w.WriteHeader(http.StatusUnauthorized)
+							return
+						}
+						ctx := static.WithLogData(r.Context(), lager.Data{"subject": "cc-uploader"})
+						files.ServeHTTP(w, r.WithContext(static.WithClientIP(ctx, "10.0.5.5")))
+					})
+				}),
+			)
+		})
+
+		It("logs and counts the responses it writes itself", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+			Expect(responseLog().Data).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusUnauthorized)))
+			Expect(metronClient.IncrementCounterArgsForCall(0)).To(Equal("Responses4xx"))
+		})
+
+		It("logs the data it attaches to the requests it passes on", func() {
+			request := httptest.NewRequest("GET", "/v1/static/test", nil)
+			request.Header.Set("Authorization", "Bearer token")
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			data := responseLog().Data
+			Expect(data).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusOK)))
+			Expect(data).To(HaveKeyWithValue("subject", "cc-uploader"))
+			Expect(data).To(HaveKeyWithValue("client-ip", "10.0.5.5"))
+		})
+	})
+
+	It("logs the responses of other handlers through LogResponses", func() {
+		other := static.LogResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}), logger, nil)
+		other.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+		data := responseLog().Data
+		Expect(data).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusNoContent)))
+		Expect(data).To(HaveKeyWithValue("uri", "/healthz"))
+	})
+
+	Context("when a metron client is configured", func() {
+		var metronClient *testhelpers.FakeIngressClient
+
+		BeforeEach(func() {
+			metronClient = new(testhelpers.FakeIngressClient)
+			handler = static.New(servedDirectory, "/v1/static/", logger, static.WithMetronClient(metronClient))
+		})
+
+		counters := func() []string {
+			var names []string
+			for i := 0; i < metronClient.IncrementCounterCallCount(); i++ {
+				names = append(names, metronClient.IncrementCounterArgsForCall(i))
+			}
+			return names
+		}
+
+		It("counts responses by status class", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/missing", nil))
+
+			Expect(counters()).To(ContainElements("Responses2xx", "Responses4xx"))
+		})
+
+		It("counts the bytes served", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+			Expect(metronClient.IncrementCounterWithDeltaCallCount()).To(Equal(1))
+			name, delta := metronClient.IncrementCounterWithDeltaArgsForCall(0)
+			Expect(name).To(Equal(static.BytesServedCounter))
+			Expect(delta).To(BeNumerically("==", 5))
+		})
+
+		It("times each request", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+			Expect(metronClient.SendDurationCallCount()).To(Equal(1))
+			name, duration, _ := metronClient.SendDurationArgsForCall(0)
+			Expect(name).To(Equal(static.RequestDurationMetric))
+			Expect(duration).To(BeNumerically(">", 0))
+		})
+
+		It("counts checksum cache misses and hits", func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+			Expect(counters()).To(Equal([]string{
+				static.ShaCacheMissCounter, "Responses2xx",
+				static.ShaCacheHitCounter, "Responses2xx",
+			}))
+		})
+
+		It("logs metrics that cannot be emitted", func() {
+			metronClient.SendDurationReturns(errors.New("boom"))
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/static/test", nil))
+
+			Expect(logger.LogMessages()).To(ContainElement("test.metrics.failed-to-emit-metric"))
+		})
+	})
+})
+
+type fakeAccessLogger struct {
+	requests []*http.Request
+	data     []lager.Data
+}
+
+func (l *fakeAccessLogger) LogResponse(req *http.Request, _ time.Time, data lager.Data) {
+	l.requests = append(l.requests, req)
+	l.data = append(l.data, data)
+}
+
+type assetObservation struct {
+	path         string
+	status, size int
+	client       string
+}
+
+type fakeAssetObserver struct {
+	observations []assetObservation
+}
+
+func (o *fakeAssetObserver) ObserveAsset(path string, status, size int, client string) {
+	o.observations = append(o.observations, assetObservation{path, status, size, client})
+}
+
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingResponseWriter) Write([]byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
@@ -0,0 +1,61 @@
+package static
+
+import (
+	"fmt"
+	"time"
+
+	loggingclient "code.cloudfoundry.org/diego-logging-client"
+	"code.cloudfoundry.org/lager/v3"
+)
+
+const (
+	// ResponsesCounterPrefix is followed by the status class of the
+	// response, e.g. Responses2xx.
+	ResponsesCounterPrefix = "Responses"
+	BytesServedCounter     = "BytesServed"
+	RequestDurationMetric  = "RequestDuration"
+	ShaCacheHitCounter     = "ShaCacheHits"
+	ShaCacheMissCounter    = "ShaCacheMisses"
+)
+
+// WithMetronClient emits request, byte, duration and checksum cache metrics
+// through client.
+func WithMetronClient(client loggingclient.IngressClient) Option {
+	return func(f *fileServer) {
+		f.metrics = &metrics{client: client, logger: lager.NewLogger("static")}
+	}
+}
+
+// metrics is safe to use when nil, which emits nothing.
+type metrics struct {
+	client loggingclient.IngressClient
+	logger lager.Logger
+}
+
+func (m *metrics) response(status, size int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.check(ResponsesCounterPrefix, m.client.IncrementCounter(fmt.Sprintf("%s%dxx", ResponsesCounterPrefix, status/100)))
+	if size > 0 {
+		m.check(BytesServedCounter, m.client.IncrementCounterWithDelta(BytesServedCounter, uint64(size)))
+	}
+	m.check(RequestDurationMetric, m.client.SendDuration(RequestDurationMetric, duration))
+}
+
+func (m *metrics) shaCache(hit bool) {
+	if m == nil {
+		return
+	}
+	name := ShaCacheMissCounter
+	if hit {
+		name = ShaCacheHitCounter
+	}
+	m.check(name, m.client.IncrementCounter(name))
+}
+
+func (m *metrics) check(name string, err error) {
+	if err != nil {
+		m.logger.Error("failed-to-emit-metric", err, lager.Data{"metric": name})
+	}
+}
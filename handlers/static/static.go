@@ -6,11 +6,40 @@ import (
 	"code.cloudfoundry.org/lager/v3"
 )
 
-func New(dir, pathPrefix string, logger lager.Logger) http.Handler {
-	fileServer := NewFileServer(dir)
-	stripped := http.StripPrefix(pathPrefix, fileServer)
+func New(dir, pathPrefix string, logger lager.Logger, opts ...Option) http.Handler {
+	fileServer := newFileServer(dir, opts...)
+	if fileServer.metrics != nil {
+		fileServer.metrics.logger = logger.Session("metrics")
+	}
+	var handler http.Handler = http.StripPrefix(pathPrefix, fileServer)
+	for _, wrap := range fileServer.middleware {
+		handler = wrap(handler)
+	}
+	return loggingHandler{
+		accessLogger:    accessLogOrDefault(fileServer.accessLogger, logger),
+		originalHandler: handler,
+		metrics:         fileServer.metrics,
+		pathPrefix:      pathPrefix,
+		assetObserver:   fileServer.assetObserver,
+	}
+}
+
+// WithMiddleware wraps the file server in middleware, such as
+// authentication, that sees requests before their path prefix is stripped.
+// The responses it writes itself are logged and counted like those of the
+// file server. Middleware added later wraps that added earlier.
+func WithMiddleware(middleware func(http.Handler) http.Handler) Option {
+	return func(f *fileServer) {
+		f.middleware = append(f.middleware, middleware)
+	}
+}
+
+// LogResponses records the responses of a handler other than the file
+// server through accessLogger, or the logger if it is nil. They are not
+// counted in the file server's metrics.
+func LogResponses(next http.Handler, logger lager.Logger, accessLogger AccessLogger) http.Handler {
 	return loggingHandler{
-		logger:          logger,
-		originalHandler: stripped,
+		accessLogger:    accessLogOrDefault(accessLogger, logger),
+		originalHandler: next,
 	}
 }
@@ -0,0 +1,115 @@
+package static
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy controls how symbolic links below the static directory are
+// treated.
+type SymlinkPolicy string
+
+const (
+	// SymlinkFollow follows every link, wherever it points. This is the
+	// default.
+	SymlinkFollow SymlinkPolicy = "follow"
+	// SymlinkFollowWithinRoot follows links as long as the file they resolve
+	// to is inside the static directory.
+	SymlinkFollowWithinRoot SymlinkPolicy = "follow_within_root"
+	// SymlinkDeny refuses to serve any path that passes through a link.
+	SymlinkDeny SymlinkPolicy = "deny"
+)
+
+// Validate checks that the policy is one of the known values. The empty
+// policy is treated as SymlinkFollow.
+func (p SymlinkPolicy) Validate() error {
+	switch p {
+	case "", SymlinkFollow, SymlinkFollowWithinRoot, SymlinkDeny:
+		return nil
+	default:
+		return fmt.Errorf("invalid symlink policy %q", p)
+	}
+}
+
+// WithSymlinkPolicy restricts which symbolic links the file server follows.
+// Files rejected by the policy are reported as not found.
+func WithSymlinkPolicy(policy SymlinkPolicy) Option {
+	return func(f *fileServer) {
+		if policy == "" || policy == SymlinkFollow {
+			return
+		}
+		f.root = symlinkFileSystem{dir: f.dir, policy: policy}
+	}
+}
+
+// symlinkFileSystem resolves the real path of every file before opening it.
+// Links swapped in between the check and the open are not caught, so the
+// policy protects against links that exist in the static directory, not
+// against a concurrent writer to it.
+type symlinkFileSystem struct {
+	dir    string
+	policy SymlinkPolicy
+}
+
+func (s symlinkFileSystem) Open(name string) (http.File, error) {
+	if filepath.Separator != '/' && strings.ContainsRune(name, filepath.Separator) {
+		return nil, fs.ErrNotExist
+	}
+	rel := filepath.FromSlash(path.Clean("/" + name))
+
+	root, err := filepath.EvalSymlinks(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	fullName := filepath.Join(root, rel)
+
+	switch s.policy {
+	case SymlinkDeny:
+		if err := rejectSymlinks(root, rel); err != nil {
+			return nil, err
+		}
+	case SymlinkFollowWithinRoot:
+		fullName, err = filepath.EvalSymlinks(fullName)
+		if err != nil {
+			return nil, err
+		}
+		if !within(root, fullName) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+	}
+
+	return os.Open(fullName)
+}
+
+// rejectSymlinks fails if any component of rel below root is a link.
+func rejectSymlinks(root, rel string) error {
+	current := root
+	for _, component := range strings.Split(strings.Trim(rel, string(filepath.Separator)), string(filepath.Separator)) {
+		if component == "" {
+			continue
+		}
+		current = filepath.Join(current, component)
+
+		info, err := os.Lstat(current)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: "open", Path: current, Err: fs.ErrPermission}
+		}
+	}
+	return nil
+}
+
+func within(root, name string) bool {
+	rel, err := filepath.Rel(root, name)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
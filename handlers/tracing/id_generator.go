@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+
+	"code.cloudfoundry.org/fileserver/handlers/requestid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type serverSpanKey struct{}
+
+// idGenerator gives the server span the trace and span IDs requestid.New
+// already sent to the client, and random IDs to every other span.
+type idGenerator struct{}
+
+func (idGenerator) NewIDs(ctx context.Context) (trace.TraceID, trace.SpanID) {
+	if ids, ok := ctx.Value(serverSpanKey{}).(requestid.TraceContext); ok {
+		return ids.TraceID, ids.SpanID
+	}
+
+	var traceID trace.TraceID
+	rand.Read(traceID[:])
+	return traceID, randomSpanID()
+}
+
+func (idGenerator) NewSpanID(ctx context.Context, traceID trace.TraceID) trace.SpanID {
+	if ids, ok := ctx.Value(serverSpanKey{}).(requestid.TraceContext); ok && ids.TraceID == traceID {
+		return ids.SpanID
+	}
+	return randomSpanID()
+}
+
+func randomSpanID() trace.SpanID {
+	var spanID trace.SpanID
+	rand.Read(spanID[:])
+	return spanID
+}
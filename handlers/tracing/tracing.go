@@ -0,0 +1,217 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"code.cloudfoundry.org/fileserver/handlers/requestid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Protocol is the OTLP transport used to export spans.
+type Protocol string
+
+const (
+	ProtocolGRPC Protocol = "grpc"
+	ProtocolHTTP Protocol = "http/protobuf"
+)
+
+// InstrumentationName names the tracer the file server creates spans with.
+const InstrumentationName = "code.cloudfoundry.org/fileserver"
+
+// shutdownTimeout bounds how long pending spans are flushed on exit.
+const shutdownTimeout = 5 * time.Second
+
+// Config enables exporting spans to the OTLP collector at Endpoint, a
+// host:port. Protocol defaults to grpc. SampleRatio is the fraction of new
+// traces recorded, 0 records all of them; requests continuing a trace
+// follow the caller's decision.
+type Config struct {
+	Endpoint           string            `json:"endpoint,omitempty"`
+	Protocol           Protocol          `json:"protocol,omitempty"`
+	Insecure           bool              `json:"insecure,omitempty"`
+	Headers            map[string]string `json:"headers,omitempty"`
+	SampleRatio        float64           `json:"sample_ratio,omitempty"`
+	ResourceAttributes map[string]string `json:"resource_attributes,omitempty"`
+}
+
+// Enabled reports whether spans are exported.
+func (c Config) Enabled() bool {
+	return c.Endpoint != ""
+}
+
+// Validate checks the protocol and the sample ratio.
+func (c Config) Validate() error {
+	switch c.Protocol {
+	case "", ProtocolGRPC, ProtocolHTTP:
+	default:
+		return fmt.Errorf("invalid tracing protocol %q, use grpc or http/protobuf", c.Protocol)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample_ratio %v must be between 0 and 1", c.SampleRatio)
+	}
+	if !c.Enabled() && (c.Protocol != "" || len(c.Headers) > 0 || len(c.ResourceAttributes) > 0) {
+		return errors.New("tracing is configured without an endpoint")
+	}
+	return nil
+}
+
+// Provider exports the spans of its tracers in batches and flushes them
+// when it is signalled.
+type Provider struct {
+	provider *sdktrace.TracerProvider
+}
+
+// NewProvider creates the exporter for config. Connections to the
+// collector are made lazily, so an unreachable collector does not fail
+// startup.
+func NewProvider(config Config) (*Provider, error) {
+	exporter, err := newExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes := []attribute.KeyValue{attribute.String("service.name", "file-server")}
+	if hostname, err := os.Hostname(); err == nil {
+		attributes = append(attributes, attribute.String("host.name", hostname))
+	}
+	for k, v := range config.ResourceAttributes {
+		attributes = append(attributes, attribute.String(k, v))
+	}
+
+	ratio := config.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	return &Provider{
+		provider: sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewSchemaless(attributes...)),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+			sdktrace.WithIDGenerator(idGenerator{}),
+		),
+	}, nil
+}
+
+func newExporter(config Config) (*otlptrace.Exporter, error) {
+	if config.Protocol == ProtocolHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(config.Endpoint), otlptracehttp.WithHeaders(config.Headers)}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(context.Background(), opts...)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint), otlptracegrpc.WithHeaders(config.Headers)}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(context.Background(), opts...)
+}
+
+// TracerProvider returns the provider to create tracers from.
+func (p *Provider) TracerProvider() trace.TracerProvider {
+	return p.provider
+}
+
+// Run waits for a signal and then exports the spans still buffered.
+func (p *Provider) Run(signals <-chan os.Signal, ready chan<- struct{}) error {
+	close(ready)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return p.provider.Shutdown(ctx)
+}
+
+// Wrap records a server span for every request next serves under route.
+// The span continues the trace requestid.New attached to the request and
+// uses the span ID it echoed in the traceparent response header.
+func Wrap(tracerProvider trace.TracerProvider, route string, next http.Handler) http.Handler {
+	tracer := tracerProvider.Tracer(InstrumentationName)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, ids, ok := requestid.FromContext(ctx)
+		if ok {
+			if ids.ParentID != [8]byte{} {
+				ctx = trace.ContextWithRemoteSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+					TraceID:    ids.TraceID,
+					SpanID:     ids.ParentID,
+					TraceFlags: trace.TraceFlags(ids.Flags),
+					Remote:     true,
+				}))
+			}
+			ctx = context.WithValue(ctx, serverSpanKey{}, ids)
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.route", route),
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("user_agent.original", r.UserAgent()),
+			),
+		)
+		defer span.End()
+		// spans started below this one get IDs of their own
+		ctx = context.WithValue(ctx, serverSpanKey{}, nil)
+
+		if ok {
+			// the sampling decision is only known now
+			ids.Flags = byte(span.SpanContext().TraceFlags())
+			w.Header().Set(requestid.TraceparentHeader, ids.Traceparent())
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", recorder.status),
+			attribute.Int("http.response.body.size", recorder.size),
+		)
+		if recorder.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	size, err := r.ResponseWriter.Write(b)
+	r.size += size
+	return size, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
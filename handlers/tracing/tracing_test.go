@@ -0,0 +1,231 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/fileserver/handlers/requestid"
+	"code.cloudfoundry.org/fileserver/handlers/static"
+	"code.cloudfoundry.org/fileserver/handlers/tracing"
+	"code.cloudfoundry.org/lager/v3/lagertest"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/tedsuo/ifrit"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeCollector keeps the spans exported to it over OTLP/HTTP or
+// OTLP/gRPC.
+type fakeCollector struct {
+	collectortrace.UnimplementedTraceServiceServer
+
+	mu        sync.Mutex
+	resources []map[string]string
+	spans     []*tracepb.Span
+}
+
+func (c *fakeCollector) Export(_ context.Context, req *collectortrace.ExportTraceServiceRequest) (*collectortrace.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resourceSpans := range req.ResourceSpans {
+		attributes := map[string]string{}
+		for _, kv := range resourceSpans.Resource.Attributes {
+			attributes[kv.Key] = kv.Value.GetStringValue()
+		}
+		c.resources = append(c.resources, attributes)
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			c.spans = append(c.spans, scopeSpans.Spans...)
+		}
+	}
+	return &collectortrace.ExportTraceServiceResponse{}, nil
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer GinkgoRecover()
+	Expect(r.URL.Path).To(Equal("/v1/traces"))
+	body, err := io.ReadAll(r.Body)
+	Expect(err).NotTo(HaveOccurred())
+
+	req := &collectortrace.ExportTraceServiceRequest{}
+	Expect(proto.Unmarshal(body, req)).To(Succeed())
+	c.Export(r.Context(), req)
+
+	response, err := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+	Expect(err).NotTo(HaveOccurred())
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(response)
+}
+
+func (c *fakeCollector) exported() ([]map[string]string, map[string]*tracepb.Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byName := map[string]*tracepb.Span{}
+	for _, span := range c.spans {
+		byName[span.Name] = span
+	}
+	return c.resources, byName
+}
+
+var _ = Describe("Tracing", func() {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		parentID = "00f067aa0ba902b7"
+	)
+
+	var (
+		collector *fakeCollector
+		config    tracing.Config
+		process   ifrit.Process
+		handler   http.Handler
+	)
+
+	BeforeEach(func() {
+		collector = &fakeCollector{}
+		server := httptest.NewServer(collector)
+		DeferCleanup(server.Close)
+
+		config = tracing.Config{
+			Endpoint:           strings.TrimPrefix(server.URL, "http://"),
+			Protocol:           tracing.ProtocolHTTP,
+			Insecure:           true,
+			ResourceAttributes: map[string]string{"deployment.environment": "test"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		servedDirectory := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(servedDirectory, "test"), []byte("hello"), 0644)).To(Succeed())
+
+		provider, err := tracing.NewProvider(config)
+		Expect(err).NotTo(HaveOccurred())
+		process = ifrit.Invoke(provider)
+
+		tracer := provider.TracerProvider().Tracer(tracing.InstrumentationName)
+		staticHandler := static.New(servedDirectory, "/v1/static/", lagertest.NewTestLogger("test"), static.WithTracer(tracer))
+		handler = requestid.New(tracing.Wrap(provider.TracerProvider(), "/v1/static/", staticHandler))
+	})
+
+	flush := func() {
+		process.Signal(os.Interrupt)
+		Eventually(process.Wait()).Should(Receive(BeNil()))
+	}
+
+	serve := func(traceparent string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/v1/static/test", nil)
+		if traceparent != "" {
+			request.Header.Set("Traceparent", traceparent)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("exports a server span with children for opening, hashing and transferring the file", func() {
+		recorder := serve("00-" + traceID + "-" + parentID + "-01")
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		flush()
+
+		resources, spans := collector.exported()
+		Expect(spans).To(HaveKey("GET /v1/static/"))
+		server := spans["GET /v1/static/"]
+		Expect(hex.EncodeToString(server.TraceId)).To(Equal(traceID))
+		Expect(hex.EncodeToString(server.ParentSpanId)).To(Equal(parentID))
+		Expect(server.Kind).To(Equal(tracepb.Span_SPAN_KIND_SERVER))
+		Expect(recorder.Header().Get("Traceparent")).To(Equal("00-" + traceID + "-" + hex.EncodeToString(server.SpanId) + "-01"))
+
+		for _, name := range []string{"open", "digest", "transfer"} {
+			Expect(spans).To(HaveKey(name))
+			Expect(spans[name].ParentSpanId).To(Equal(server.SpanId))
+			Expect(spans[name].SpanId).NotTo(Equal(server.SpanId))
+		}
+
+		Expect(resources).NotTo(BeEmpty())
+		Expect(resources[0]).To(HaveKeyWithValue("service.name", "file-server"))
+		Expect(resources[0]).To(HaveKeyWithValue("deployment.environment", "test"))
+	})
+
+	It("starts a trace when the request does not continue one", func() {
+		recorder := serve("")
+		flush()
+
+		_, spans := collector.exported()
+		server := spans["GET /v1/static/"]
+		Expect(server).NotTo(BeNil())
+		Expect(server.ParentSpanId).To(BeEmpty())
+		Expect(recorder.Header().Get("Traceparent")).To(Equal("00-" + hex.EncodeToString(server.TraceId) + "-" + hex.EncodeToString(server.SpanId) + "-01"))
+	})
+
+	Context("when only a fraction of traces is sampled", func() {
+		BeforeEach(func() {
+			config.SampleRatio = 1e-9
+		})
+
+		It("does not export new traces that were not sampled", func() {
+			recorder := serve("")
+			flush()
+
+			_, spans := collector.exported()
+			Expect(spans).To(BeEmpty())
+			Expect(recorder.Header().Get("Traceparent")).To(HaveSuffix("-00"))
+		})
+
+		It("follows the decision of the caller", func() {
+			serve("00-" + traceID + "-" + parentID + "-01")
+			flush()
+
+			_, spans := collector.exported()
+			Expect(spans).To(HaveKey("GET /v1/static/"))
+		})
+	})
+
+	Context("when exporting over gRPC", func() {
+		BeforeEach(func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			server := grpc.NewServer()
+			collectortrace.RegisterTraceServiceServer(server, collector)
+			go server.Serve(listener)
+			DeferCleanup(server.Stop)
+
+			config.Endpoint = listener.Addr().String()
+			config.Protocol = tracing.ProtocolGRPC
+		})
+
+		It("exports the spans", func() {
+			serve("")
+			flush()
+
+			_, spans := collector.exported()
+			Expect(spans).To(HaveKey("GET /v1/static/"))
+			Expect(spans).To(HaveKey("transfer"))
+		})
+	})
+
+	DescribeTable("Validate",
+		func(config tracing.Config, expectedError string) {
+			err := config.Validate()
+			if expectedError == "" {
+				Expect(err).NotTo(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(ContainSubstring(expectedError)))
+			}
+		},
+		Entry("disabled", tracing.Config{}, ""),
+		Entry("grpc", tracing.Config{Endpoint: "otel-collector:4317", SampleRatio: 0.1}, ""),
+		Entry("http", tracing.Config{Endpoint: "otel-collector:4318", Protocol: tracing.ProtocolHTTP}, ""),
+		Entry("an unknown protocol", tracing.Config{Endpoint: "otel-collector:4317", Protocol: "thrift"}, "invalid tracing protocol"),
+		Entry("a sample ratio above 1", tracing.Config{Endpoint: "otel-collector:4317", SampleRatio: 1.5}, "must be between 0 and 1"),
+		Entry("settings without an endpoint", tracing.Config{Protocol: tracing.ProtocolHTTP}, "without an endpoint"),
+	)
+})
@@ -3,9 +3,13 @@ package fileserver
 import "github.com/tedsuo/rata"
 
 const (
-	StaticRoute = "Static"
+	StaticRoute  = "Static"
+	HealthzRoute = "Healthz"
+	ReadyzRoute  = "Readyz"
 )
 
 var Routes = rata.Routes{
 	{Name: StaticRoute, Method: "GET", Path: "/v1/static/"},
+	{Name: HealthzRoute, Method: "GET", Path: "/healthz"},
+	{Name: ReadyzRoute, Method: "GET", Path: "/readyz"},
 }